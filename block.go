@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// audit events
+const (
+	auditBlocked   = "blocked"
+	auditUnblocked = "unblocked"
+)
+
+type auditRecord struct {
+	User  string    `json:"user"`
+	Event string    `json:"event"`
+	At    time.Time `json:"at"`
+}
+
+// blockList knows which users blocked the bot. Its journal doubles as the
+// audit trail, so unlike the other stores it is never compacted.
+type blockList struct {
+	mu      sync.Mutex
+	blocked map[string]time.Time
+	journal *journal
+}
+
+func newBlockList() *blockList {
+	return &blockList{blocked: make(map[string]time.Time)}
+}
+
+// openBlockList returns a blockList that appends every block and unblock to
+// the audit log at path.
+func openBlockList(path string) (*blockList, error) {
+	b := newBlockList()
+	j, err := openJournal(path, func(line []byte) error {
+		var r auditRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		b.apply(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.journal = j
+	return b, nil
+}
+
+func (b *blockList) apply(r auditRecord) {
+	switch r.Event {
+	case auditBlocked:
+		b.blocked[r.User] = r.At
+	case auditUnblocked:
+		delete(b.blocked, r.User)
+	}
+}
+
+func (b *blockList) record(user, event string) {
+	r := auditRecord{User: user, Event: event, At: time.Now()}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.apply(r)
+	log.Printf("audit: %s %s", user, event)
+	if b.journal == nil {
+		return
+	}
+	if err := b.journal.Append(r); err != nil {
+		log.Println(err)
+	}
+}
+
+// Block records that user blocked the bot.
+func (b *blockList) Block(user string) {
+	b.record(user, auditBlocked)
+}
+
+// Unblock records that user added the bot again.
+func (b *blockList) Unblock(user string) {
+	b.record(user, auditUnblocked)
+}
+
+// Blocked reports whether user has blocked the bot. Nothing is sent to them
+// until they add it again.
+func (b *blockList) Blocked(user string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.blocked[user]
+	return ok
+}
+
+// purgeUser deletes everything kept about user, including the short links
+// issued to them, and takes them out of their lunch poll and daily pushes.
+func purgeUser(user string) {
+	if err := sessions.Delete(user); err != nil {
+		log.Println(err)
+	}
+	history.Forget(user)
+	profiles.Clear(user)
+	favorites.Forget(user)
+	subscriptions.Forget(user)
+	polls.Leave(user)
+	if links != nil {
+		links.Forget(user)
+	}
+	if c, ok := shortener.(*shortURLCache); ok {
+		c.Forget(user)
+	}
+}
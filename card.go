@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// result card layout, in 1040 canvas pixels
+const (
+	cardPhotoHeight = 620
+	cardMargin      = 40
+	cardChipHeight  = 72
+	cardPreviewSize = 240
+)
+
+// formatDistance renders meters the way the card shows them.
+func formatDistance(meters float64) string {
+	if meters < 1000 {
+		return fmt.Sprintf("%d M", int(meters+0.5))
+	}
+	return fmt.Sprintf("%.1f KM", meters/1000)
+}
+
+// categoryLabels returns what to print on each category chip: the category
+// name when the font can draw it, its alias otherwise.
+func categoryLabels(b Place) []string {
+	var labels []string
+	for _, c := range b.Categories {
+		label := drawable(c.Name)
+		if label == "" {
+			label = drawable(c.Alias)
+		}
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// cardTitle is what a card prints as b's name. When no font can draw the
+// name, its first category stands in, so the card never goes blank.
+func cardTitle(b Place) string {
+	if title := drawable(b.Name); title != "" {
+		return title
+	}
+	if labels := categoryLabels(b); len(labels) > 0 {
+		return labels[0]
+	}
+	return "RESTAURANT"
+}
+
+// renderCard draws a 1040 by 1040 result card for b: photo, name, stars,
+// review count, distance and category chips. photo may be nil.
+func renderCard(b Place, photo image.Image) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, richCanvasSize, richCanvasSize))
+	fill(canvas, canvas.Bounds(), colorCardBackground)
+
+	photoArea := image.Rect(0, 0, richCanvasSize, cardPhotoHeight)
+	if photo != nil {
+		scaleCover(canvas, photoArea, photo)
+	} else {
+		fill(canvas, photoArea, colorCardMuted)
+	}
+
+	y := cardPhotoHeight + 36
+	scale := 7
+	drawText(canvas, cardMargin, y, scale, colorCardText, fitText(cardTitle(b), scale, richCanvasSize-2*cardMargin))
+	y += glyphHeight*scale + 32
+
+	starSize := 56
+	drawStars(canvas, cardMargin, y, starSize, b.Rating)
+	x := cardMargin + 5*starSize + 4*(starSize/5) + 24
+	scale = 5
+	drawText(canvas, x, y+(starSize-glyphHeight*scale)/2, scale, colorCardText, fmt.Sprintf("(%d)", b.ReviewCount))
+	if b.Distance > 0 {
+		d := formatDistance(b.Distance)
+		drawText(canvas, richCanvasSize-cardMargin-textWidth(d, scale), y+(starSize-glyphHeight*scale)/2, scale, colorCardText, d)
+	}
+	y += starSize + 36
+
+	x = cardMargin
+	scale = 4
+	for _, label := range categoryLabels(b) {
+		label = fitText(label, scale, richCanvasSize-2*cardMargin-48)
+		w := textWidth(label, scale) + 48
+		if x+w > richCanvasSize-cardMargin {
+			break
+		}
+		fill(canvas, image.Rect(x, y, x+w, y+cardChipHeight), colorCardMuted)
+		drawText(canvas, x+24, y+(cardChipHeight-glyphHeight*scale)/2, scale, colorCardText, label)
+		x += w + 16
+	}
+	return canvas
+}
+
+// cardCache keeps rendered cards on disk, one file per business and size.
+// Only the full size card is drawn from the photo; smaller ones are scaled
+// down from it.
+type cardCache struct {
+	dir string
+	// prefix is the path the cache serves, such as "/card/"
+	prefix string
+	render func(b Place, photo image.Image) *image.RGBA
+	mu     sync.Mutex
+	// rendering guards against drawing the same card twice at once
+	rendering map[string]*sync.WaitGroup
+}
+
+func newCardCache(dir, prefix string, render func(b Place, photo image.Image) *image.RGBA) *cardCache {
+	return &cardCache{dir: dir, prefix: prefix, render: render, rendering: make(map[string]*sync.WaitGroup)}
+}
+
+func (c *cardCache) path(id string, size int) string {
+	sum := sha1.Sum([]byte(id))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+"-"+strconv.Itoa(size)+".png")
+}
+
+// Get returns the PNG card for b at size, rendering it on a miss.
+func (c *cardCache) Get(b Place, size int) ([]byte, error) {
+	p := c.path(b.ID, size)
+	if data, err := ioutil.ReadFile(p); err == nil {
+		return data, nil
+	}
+
+	c.mu.Lock()
+	if wg, ok := c.rendering[p]; ok {
+		c.mu.Unlock()
+		wg.Wait()
+		return ioutil.ReadFile(p)
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	c.rendering[p] = wg
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.rendering, p)
+		c.mu.Unlock()
+		wg.Done()
+	}()
+
+	var img image.Image
+	if size == richCanvasSize {
+		photo, err := fetchImage(b.PhotoURL)
+		if err != nil {
+			log.Println(err)
+		}
+		img = c.render(b, photo)
+	} else {
+		full, err := c.Get(b, richCanvasSize)
+		if err != nil {
+			return nil, err
+		}
+		if img, err = png.Decode(bytes.NewReader(full)); err != nil {
+			return nil, err
+		}
+		img = resizeSquare(img, size)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, err
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), os.Rename(tmp, p)
+}
+
+// cached returns the card for id at size if it has been rendered before.
+func (c *cardCache) cached(id string, size int) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.path(id, size))
+	return data, err == nil
+}
+
+// cardURLs returns the image and preview URLs of b's card.
+func cardURLs(b Place) (string, string) {
+	base := publicURL + "/card/" + url.PathEscape(b.ID) + "/"
+	return base + strconv.Itoa(richCanvasSize), base + strconv.Itoa(cardPreviewSize)
+}
+
+// ServeHTTP serves {prefix}{business}/{width}.
+func (c *cardCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, c.prefix)
+	slash := strings.LastIndex(path, "/")
+	if slash < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	id := path[:slash]
+	size, err := strconv.Atoi(path[slash+1:])
+	if err != nil || !richImageSizes[size] {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, ok := c.cached(id, size)
+	if !ok {
+		b, known := recentBusinesses.Get(id)
+		if !known {
+			http.NotFound(w, r)
+			return
+		}
+		data, err = c.Get(b, size)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(data)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"net/http"
+	"time"
+)
+
+// richImageSizes are the widths LINE asks a rich message image for, by
+// appending /{width} to its download URL.
+var richImageSizes = map[int]bool{1040: true, 700: true, 460: true, 300: true, 240: true}
+
+const richCanvasSize = 1040
+
+// rich card layout, in 1040 canvas pixels
+const (
+	richPhotoHeight  = 700
+	richButtonsTop   = 860
+	richButtonHeight = richCanvasSize - richButtonsTop
+	richButtonWidth  = richCanvasSize / 3
+)
+
+var (
+	colorCardBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	colorCardText       = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	colorCardMuted      = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	colorStar           = color.RGBA{0xf1, 0x5c, 0x4f, 0xff}
+	colorButton         = color.RGBA{0xd3, 0x23, 0x23, 0xff}
+	colorButtonAlt      = color.RGBA{0x00, 0xb9, 0x00, 0xff}
+	colorButtonText     = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+var imageHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchImage downloads and decodes a JPEG or PNG.
+func fetchImage(imageURL string) (image.Image, error) {
+	if imageURL == "" {
+		return nil, fmt.Errorf("no image url")
+	}
+	response, err := imageHTTPClient.Get(imageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", imageURL, response.Status)
+	}
+	img, _, err := image.Decode(response.Body)
+	return img, err
+}
+
+// scaleCover scales src to fill r of dst, cropping whatever overflows so the
+// aspect ratio is kept.
+func scaleCover(dst draw.Image, r image.Rectangle, src image.Image) {
+	sb := src.Bounds()
+	if sb.Empty() || r.Empty() {
+		return
+	}
+	scale := math.Max(float64(r.Dx())/float64(sb.Dx()), float64(r.Dy())/float64(sb.Dy()))
+	offX := (float64(sb.Dx())*scale - float64(r.Dx())) / 2
+	offY := (float64(sb.Dy())*scale - float64(r.Dy())) / 2
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		sy := sb.Min.Y + int((float64(y-r.Min.Y)+offY)/scale)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sx := sb.Min.X + int((float64(x-r.Min.X)+offX)/scale)
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+}
+
+// fill paints r of dst with c.
+func fill(dst draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(dst, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// drawStars draws five stars of size pixels starting at (x, y), filled in
+// proportion to rating out of 5.
+func drawStars(dst draw.Image, x, y, size int, rating float64) {
+	gap := size / 5
+	for i := 0; i < 5; i++ {
+		filled := rating - float64(i)
+		if filled > 1 {
+			filled = 1
+		} else if filled < 0 {
+			filled = 0
+		}
+		drawStar(dst, x+i*(size+gap), y, size, filled)
+	}
+}
+
+// drawStar draws one star, colouring the left filled fraction of it.
+func drawStar(dst draw.Image, x, y, size int, filled float64) {
+	cx, cy := float64(x)+float64(size)/2, float64(y)+float64(size)/2
+	outer, inner := float64(size)/2, float64(size)/5
+	var pts [10][2]float64
+	for i := range pts {
+		rad := outer
+		if i%2 == 1 {
+			rad = inner
+		}
+		a := -math.Pi/2 + float64(i)*math.Pi/5
+		pts[i] = [2]float64{cx + rad*math.Cos(a), cy + rad*math.Sin(a)}
+	}
+	split := float64(x) + filled*float64(size)
+	for py := y; py < y+size; py++ {
+		for px := x; px < x+size; px++ {
+			if !insidePolygon(pts[:], float64(px)+0.5, float64(py)+0.5) {
+				continue
+			}
+			if float64(px) < split {
+				dst.Set(px, py, colorStar)
+			} else {
+				dst.Set(px, py, colorCardMuted)
+			}
+		}
+	}
+}
+
+func insidePolygon(pts [][2]float64, x, y float64) bool {
+	in := false
+	for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+		xi, yi := pts[i][0], pts[i][1]
+		xj, yj := pts[j][0], pts[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+// drawButton paints a labelled button filling r.
+func drawButton(dst draw.Image, r image.Rectangle, c color.Color, label string) {
+	fill(dst, r.Inset(8), c)
+	scale := 8
+	label = fitText(label, scale, r.Dx()-48)
+	tx := r.Min.X + (r.Dx()-textWidth(label, scale))/2
+	ty := r.Min.Y + (r.Dy()-glyphHeight*scale)/2
+	drawText(dst, tx, ty, scale, colorButtonText, label)
+}
+
+// composeRichImage lays out a 1040 by 1040 rich message canvas: the photo on
+// top, then the name and rating, then the MAP, SAVE and MORE buttons.
+func composeRichImage(photo image.Image, name string, rating float64) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, richCanvasSize, richCanvasSize))
+	fill(canvas, canvas.Bounds(), colorCardBackground)
+
+	photoArea := image.Rect(0, 0, richCanvasSize, richPhotoHeight)
+	if photo != nil {
+		scaleCover(canvas, photoArea, photo)
+	} else {
+		fill(canvas, photoArea, colorCardMuted)
+	}
+
+	scale := 6
+	drawText(canvas, 32, richPhotoHeight+28, scale, colorCardText, fitText(name, scale, richCanvasSize-64))
+	drawStars(canvas, 32, richPhotoHeight+28+glyphHeight*scale+24, 56, rating)
+
+	drawButton(canvas, image.Rect(0, richButtonsTop, richButtonWidth, richCanvasSize), colorButtonAlt, "MAP")
+	drawButton(canvas, image.Rect(richButtonWidth, richButtonsTop, 2*richButtonWidth, richCanvasSize), colorButtonAlt, "SAVE")
+	drawButton(canvas, image.Rect(2*richButtonWidth, richButtonsTop, richCanvasSize, richCanvasSize), colorButton, "MORE")
+	return canvas
+}
+
+// resizeSquare returns img scaled to size by size.
+func resizeSquare(img image.Image, size int) image.Image {
+	if img.Bounds().Dx() == size && img.Bounds().Dy() == size {
+		return img
+	}
+	out := image.NewRGBA(image.Rect(0, 0, size, size))
+	scaleCover(out, out.Bounds(), img)
+	return out
+}
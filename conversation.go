@@ -0,0 +1,277 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+const (
+	promptImage    = "http://imageshack.com/a/img921/318/DC21al.png"
+	promptWelcome  = "Hi~\n歡迎加入 Delicious!\n\n想查詢附近或各地美食都可以LINE我呦！\n\n請問你想吃什麼?\nex:義大利麵\n\n想不到吃什麼，也可以直接'傳送目前位置訊息'"
+	promptFood     = "請問你想吃什麼?\nex:義大利麵\n\n想不到吃什麼，也可以直接'傳送目前位置訊息'\nex："
+	promptLocation = "你在哪裡?\n請'手動輸入目前位置'\nex:台北市信義區...\n或是利用'傳送目前位置訊息'\nex："
+	promptNotFound = "查無資料！\n請重新輸入\n\n" + promptFood
+	promptNoMore   = "已無更多資料！"
+
+	// what can be done with the recommendations just sent
+	promptFollowUps = promptFeedback + "\n" + promptSaveHint + "\n" + promptDetailsHint
+)
+
+// foodDialog is the food -> location -> results conversation.
+var foodDialog = newDialog(StateAwaitingFood).
+	Command(pollCommand).
+	Command(feedbackCommand).
+	Command(preferencesCommand).
+	Command(favoritesCommand).
+	Command(detailsCommand).
+	Command(subscriptionCommand).
+	Allow(StateAwaitingFood, StateAwaitingLocation, StateShowingResults).
+	Allow(StateAwaitingLocation, StateShowingResults, StateChoosingPlace).
+	Allow(StateChoosingPlace, StateShowingResults, StateAwaitingLocation).
+	Allow(StateShowingResults, StateAwaitingLocation).
+	Handle(StateAwaitingFood, &stateHandler{
+		OnText:     askLocation,
+		OnLocation: searchNearby,
+	}).
+	Handle(StateAwaitingLocation, &stateHandler{
+		OnText:     searchByText,
+		OnLocation: searchNearby,
+	}).
+	Handle(StateChoosingPlace, &stateHandler{
+		OnText:     choosePlace,
+		OnLocation: searchNearby,
+	}).
+	Handle(StateShowingResults, &stateHandler{
+		OnText:     moreOrNewFood,
+		OnLocation: searchNearby,
+	}).
+	Fallback(&stateHandler{
+		OnSticker:   repeatPrompt,
+		OnOperation: onOperation,
+	})
+
+func askLocation(c *dialogContext, text *linebot.ReceivedTextContent) DialogState {
+	c.Session.Food = text.Text
+	c.Reply.Prompt(promptLocation)
+	return StateAwaitingLocation
+}
+
+func searchNearby(c *dialogContext, loc *linebot.ReceivedLocationContent) DialogState {
+	subscriptions.RememberLocation(c.User, loc.Latitude, loc.Longitude)
+	search := &lastSearch{
+		Query:       c.Session.Food,
+		Coordinates: true,
+		Latitude:    loc.Latitude,
+		Longitude:   loc.Longitude,
+	}
+	if err := showSearch(c, search); err != nil {
+		if err == errResultsExhausted {
+			suggestFood(c, search)
+		} else {
+			log.Println(err)
+		}
+		c.Reply.Prompt(promptNotFound)
+		return StateAwaitingFood
+	}
+	c.Session.Search = search
+	c.Reply.Text(promptMore)
+	c.Reply.Prompt(promptFood)
+	return StateShowingResults
+}
+
+// suggestFood offers what the provider would search for instead, when s
+// found nothing at all and the provider can tell.
+func suggestFood(c *dialogContext, s *lastSearch) {
+	suggester, ok := c.Places.(termSuggester)
+	if !ok || s.Query == "" || len(s.Shown) > 0 {
+		return
+	}
+	var near *LatLng
+	if s.Latitude != 0 || s.Longitude != 0 {
+		near = &LatLng{Latitude: s.Latitude, Longitude: s.Longitude}
+	}
+	terms, err := suggester.SuggestTerms(s.Query, near)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(terms) > 3 {
+		terms = terms[:3]
+	}
+	if len(terms) > 0 {
+		c.Reply.Text("找不到「" + s.Query + "」，可以試試：" + strings.Join(terms, "、"))
+	}
+}
+
+// searchByText searches for the food around the place the user typed. When
+// the text could mean several places, the user picks one first.
+func searchByText(c *dialogContext, text *linebot.ReceivedTextContent) DialogState {
+	matches, err := geocoder.Geocode(text.Text)
+	if err != nil {
+		log.Println(err)
+	}
+	if len(matches) > 1 {
+		if len(matches) > maxPlaceChoices {
+			matches = matches[:maxPlaceChoices]
+		}
+		c.Session.Places = matches
+		c.Reply.Text(placeChoices(matches))
+		return StateChoosingPlace
+	}
+
+	search := &lastSearch{
+		Query:    c.Session.Food,
+		Location: text.Text,
+	}
+	// the text may be a full address, so it stays the location and the
+	// match only hints where it is
+	if len(matches) == 1 {
+		search.Latitude = matches[0].Latitude
+		search.Longitude = matches[0].Longitude
+	}
+	return searchPlace(c, search, text)
+}
+
+// choosePlace searches around the place the user picked by number. Anything
+// else is taken as a new location.
+func choosePlace(c *dialogContext, text *linebot.ReceivedTextContent) DialogState {
+	choices := c.Session.Places
+	c.Session.Places = nil
+	n, err := strconv.Atoi(strings.TrimSpace(text.Text))
+	if err != nil || n < 1 || n > len(choices) {
+		return searchByText(c, text)
+	}
+	place := choices[n-1]
+	return searchPlace(c, &lastSearch{
+		Query:     c.Session.Food,
+		Location:  place.Label,
+		Latitude:  place.Latitude,
+		Longitude: place.Longitude,
+	}, text)
+}
+
+func searchPlace(c *dialogContext, search *lastSearch, text *linebot.ReceivedTextContent) DialogState {
+	if err := showSearch(c, search); err != nil {
+		if err == errResultsExhausted {
+			suggestFood(c, search)
+			c.Reply.Prompt(promptNotFound)
+			return StateAwaitingFood
+		}
+		if err == errUnknownLocation {
+			// the user most likely changed their mind about the food rather
+			// than told us where they are
+			c.Session.Food = text.Text
+			c.Reply.Prompt(promptLocation)
+			return StateAwaitingLocation
+		}
+		log.Println(err)
+		c.Reply.Prompt(promptNotFound)
+		return StateAwaitingFood
+	}
+	c.Session.Search = search
+	c.Reply.Text(promptMore)
+	c.Reply.Prompt(promptFood)
+	return StateShowingResults
+}
+
+// moreOrNewFood pages through the last search when asked to, and otherwise
+// starts over with text as the food.
+func moreOrNewFood(c *dialogContext, text *linebot.ReceivedTextContent) DialogState {
+	if !isMoreRequest(text.Text) || c.Session.Search == nil {
+		c.Session.Search = nil
+		return askLocation(c, text)
+	}
+	if err := showSearch(c, c.Session.Search); err != nil {
+		if err != errResultsExhausted {
+			log.Println(err)
+		}
+		c.Reply.Prompt(promptExhausted)
+		return StateAwaitingFood
+	}
+	c.Reply.Text(promptMore)
+	c.Reply.Prompt(promptFood)
+	return StateShowingResults
+}
+
+func repeatPrompt(c *dialogContext, sticker *linebot.ReceivedStickerContent) DialogState {
+	switch {
+	case c.Session.State == StateAwaitingLocation:
+		c.Reply.Prompt(promptLocation)
+	case c.Session.State == StateChoosingPlace && len(c.Session.Places) > 0:
+		c.Reply.Text(placeChoices(c.Session.Places))
+	default:
+		c.Reply.Prompt(promptFood)
+	}
+	return ""
+}
+
+// onOperation welcomes new friends from a clean slate and forgets users who
+// block the bot.
+func onOperation(c *dialogContext, op *linebot.ReceivedOperation) DialogState {
+	switch op.OpType {
+	case linebot.OpTypeAddedAsFriend:
+		if blocks.Blocked(c.User) {
+			blocks.Unblock(c.User)
+		}
+		*c.Session = Session{State: StateAwaitingFood}
+		c.Reply.Prompt(promptWelcome)
+		return StateAwaitingFood
+	case linebot.OpTypeBlocked:
+		blocks.Block(c.User)
+		purgeUser(c.User)
+		*c.Session = Session{State: StateAwaitingFood}
+		return StateAwaitingFood
+	}
+	return ""
+}
+
+// showResults adds up to three businesses to the reply, drawn by the
+// recommender from the user's personalized candidates, and returns the ones
+// it added. It says so when that was the last of what results.Total
+// promises.
+func showResults(c *dialogContext, results PlaceResults) []Place {
+	if len(results.Places) == 0 {
+		c.Reply.Prompt(promptNotFound)
+		return nil
+	}
+	candidates := personalize(c.User, results.Places)
+	shown := recommender.Sample(candidates, 3)
+	prefetchShortLinks(c.User, shown)
+	for _, b := range shown {
+		addBusiness(c.Reply, c.User, b)
+	}
+	history.Recommended(c.User, shown)
+	if len(shown) < 3 && results.Total <= len(results.Places) {
+		c.Reply.Text(promptNoMore)
+	}
+	c.Reply.Text(promptFollowUps)
+	return shown
+}
+
+// addBusiness adds the photo, details and location of b to r.
+func addBusiness(r *reply, user string, b Place) {
+	urlOrig := UrlShortener{}
+	urlOrig.shortFor(b.URL, b.ID, user)
+	address := strings.Join(b.Address, ",")
+	recentBusinesses.Add(b)
+
+	info := "店名：" + b.Name + "\n電話：" + b.Phone + "\n評比：" + strconv.FormatFloat(b.Rating, 'f', 1, 64)
+	if urlOrig.ShortUrl != "" {
+		info += "\n更多資訊：" + urlOrig.ShortUrl
+	}
+	if richCards {
+		addRichCard(r, user, b, urlOrig.ShortUrl)
+		r.Text(info)
+		return
+	}
+	if cards != nil {
+		r.Image(cardURLs(b))
+	} else if b.PhotoURL != "" {
+		r.Image(b.PhotoURL, b.PhotoURL)
+	}
+	r.Text(info)
+	r.Location(b.Name+"\n", address, b.Latitude, b.Longitude)
+}
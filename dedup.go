@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// duplicate deliveries dropped, published on /debug/vars
+var duplicatesDropped = expvar.NewInt("duplicates_dropped")
+
+// dedupCache remembers recently accepted event IDs so that a webhook LINE
+// redelivers is not processed twice.
+type dedupCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastSweep time.Time
+	journal   *journal
+}
+
+func newDedupCache(ttl time.Duration) *dedupCache {
+	return &dedupCache{
+		ttl:       ttl,
+		seen:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+type dedupRecord struct {
+	Key     string    `json:"key"`
+	At      time.Time `json:"at,omitempty"`
+	Release bool      `json:"release,omitempty"`
+}
+
+// openDedupCache returns a dedupCache that also journals to path, so events
+// accepted just before a restart are still recognised after it.
+func openDedupCache(path string, ttl time.Duration) (*dedupCache, error) {
+	d := newDedupCache(ttl)
+	j, err := openJournal(path, func(line []byte) error {
+		var r dedupRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if r.Release {
+			delete(d.seen, r.Key)
+		} else {
+			d.seen[r.Key] = r.At
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := j.Compact(d.live()); err != nil {
+		j.Close()
+		return nil, err
+	}
+	d.journal = j
+	return d, nil
+}
+
+// eventKeys returns the IDs a delivery is recognised by.
+func eventKeys(result *linebot.ReceivedResult) []string {
+	keys := []string{"result:" + result.ID}
+	if result.RawContent.ID != "" {
+		keys = append(keys, "content:"+result.RawContent.ID)
+	}
+	return keys
+}
+
+// Claim marks keys as seen and reports whether none of them had been seen
+// before. A false return means the event is a duplicate.
+func (d *dedupCache) Claim(keys ...string) bool {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+	for _, key := range keys {
+		if _, ok := d.seen[key]; ok {
+			duplicatesDropped.Add(1)
+			return false
+		}
+	}
+	for _, key := range keys {
+		d.seen[key] = now
+		d.append(dedupRecord{Key: key, At: now})
+	}
+	return true
+}
+
+// Release forgets keys, for events that were claimed but could not be
+// accepted and will be redelivered.
+func (d *dedupCache) Release(keys ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range keys {
+		delete(d.seen, key)
+		d.append(dedupRecord{Key: key, Release: true})
+	}
+}
+
+// Dropped returns how many duplicate deliveries have been dropped.
+func (d *dedupCache) Dropped() int64 {
+	return duplicatesDropped.Value()
+}
+
+// live drops expired keys and returns the rest as records. Callers hold
+// d.mu, or own d exclusively.
+func (d *dedupCache) live() []interface{} {
+	now := time.Now()
+	var live []interface{}
+	for key, at := range d.seen {
+		if now.Sub(at) > d.ttl {
+			delete(d.seen, key)
+			continue
+		}
+		live = append(live, dedupRecord{Key: key, At: at})
+	}
+	return live
+}
+
+// append journals r. Callers hold d.mu.
+func (d *dedupCache) append(r dedupRecord) {
+	if d.journal == nil {
+		return
+	}
+	if err := d.journal.Append(r); err != nil {
+		log.Println(err)
+	}
+	if err := d.journal.CompactIfDue(d.live); err != nil {
+		log.Println(err)
+	}
+}
+
+// sweep drops expired keys, at most once per TTL. Callers hold d.mu.
+func (d *dedupCache) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.ttl {
+		return
+	}
+	for key, at := range d.seen {
+		if now.Sub(at) > d.ttl {
+			delete(d.seen, key)
+		}
+	}
+	d.lastSweep = now
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func TestDedupClaimRelease(t *testing.T) {
+	d := newDedupCache(time.Hour)
+	if !d.Claim("result:1", "content:a") {
+		t.Fatal("first delivery refused")
+	}
+	if d.Claim("result:1") {
+		t.Error("redelivery with the same result ID accepted")
+	}
+	if d.Claim("result:2", "content:a") {
+		t.Error("redelivery with the same content ID accepted")
+	}
+
+	// a claim that could not be queued is released for LINE to redeliver
+	d.Release("result:1", "content:a")
+	if !d.Claim("result:1", "content:a") {
+		t.Error("released event refused")
+	}
+}
+
+func TestDedupExpires(t *testing.T) {
+	d := newDedupCache(time.Hour)
+	d.Claim("old")
+	d.seen["old"] = time.Now().Add(-2 * time.Hour)
+	d.lastSweep = time.Now().Add(-2 * time.Hour)
+
+	if !d.Claim("new") {
+		t.Fatal("new key refused")
+	}
+	if _, ok := d.seen["old"]; ok {
+		t.Error("expired key not swept")
+	}
+	if !d.Claim("old") {
+		t.Error("expired key still refused")
+	}
+}
+
+func TestDedupSurvivesRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.log")
+
+	d, err := openDedupCache(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Claim("result:1")
+	d.Claim("result:2")
+	d.Release("result:2")
+	d.journal.Append(dedupRecord{Key: "result:stale", At: time.Now().Add(-2 * time.Hour)})
+	d.journal.Close()
+
+	d, err = openDedupCache(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.journal.Close()
+	if d.Claim("result:1") {
+		t.Error("event claimed before the restart accepted again")
+	}
+	if !d.Claim("result:2") {
+		t.Error("released event refused after the restart")
+	}
+	if !d.Claim("result:stale") {
+		t.Error("expired event refused after the restart")
+	}
+}
+
+func TestEventKeys(t *testing.T) {
+	r := &linebot.ReceivedResult{ID: "r1"}
+	if keys := eventKeys(r); len(keys) != 1 || keys[0] != "result:r1" {
+		t.Errorf("keys = %v", keys)
+	}
+	r.RawContent.ID = "c1"
+	if keys := eventKeys(r); len(keys) != 2 || keys[1] != "content:c1" {
+		t.Errorf("keys = %v", keys)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	promptDetailsHint     = "回覆「詳細 1」看第一家的評論和優惠"
+	promptBusinessGone    = "找不到這家店的資料了，可能已經下架"
+	promptDetailsFailed   = "暫時查不到詳細資料，請稍後再試"
+	promptNothingToDetail = "還沒有推薦過餐廳給你喔！\n\n" + promptFood
+)
+
+var detailsPattern = regexp.MustCompile(`(?i)^\s*(?:詳細|詳情|details?|info)\s*([1-9])?\s*$`)
+
+// detailsCommand looks up one of the last recommendations in full.
+func detailsCommand(c *dialogContext, text string) (DialogState, bool) {
+	m := detailsPattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", false
+	}
+	ids := history.LastTurn(c.User)
+	if len(ids) == 0 {
+		c.Reply.Prompt(promptNothingToDetail)
+		return "", true
+	}
+	n := 1
+	if m[1] != "" {
+		n, _ = strconv.Atoi(m[1])
+	}
+	if n > len(ids) {
+		c.Reply.Text(promptNoSuchPlace)
+		return "", true
+	}
+
+	b, err := c.Places.Details(ids[n-1])
+	switch {
+	case err == errPlaceNotFound:
+		c.Reply.Text(promptBusinessGone)
+	case err != nil:
+		log.Println(err)
+		c.Reply.Text(promptDetailsFailed)
+	default:
+		c.Reply.Text(businessDetails(b, time.Now().In(localZone)))
+	}
+	return "", true
+}
+
+// businessDetails describes what only a details lookup returns: whether b is
+// still in business and open at now, where exactly it is, its hours, a
+// review, and its deals and gift certificates.
+func businessDetails(b Place, now time.Time) string {
+	lines := []string{"店名：" + b.Name}
+	switch {
+	case b.IsClosed:
+		lines = append(lines, "狀態：已歇業")
+	case len(b.Hours) == 0:
+		// IsClosed only says the place has not closed down for good
+	case openAt(b.Hours, now):
+		lines = append(lines, "狀態：營業中")
+	default:
+		lines = append(lines, "狀態：休息中")
+	}
+	if b.CrossStreets != "" {
+		lines = append(lines, "路口："+b.CrossStreets)
+	}
+	if len(b.Neighborhoods) > 0 {
+		lines = append(lines, "商圈："+strings.Join(b.Neighborhoods, "、"))
+	}
+	if len(b.Hours) > 0 {
+		lines = append(lines, "營業時間：")
+		lines = append(lines, formatHours(b.Hours)...)
+	}
+	if b.Snippet != "" {
+		lines = append(lines, "", "簡介："+b.Snippet)
+	}
+	for _, r := range b.Reviews {
+		if r.Excerpt == "" {
+			continue
+		}
+		review := "評論（" + strconv.FormatFloat(r.Rating, 'f', 1, 64) + "）：" + r.Excerpt
+		if r.Author != "" {
+			review += " — " + r.Author
+		}
+		lines = append(lines, "", review)
+	}
+	for _, d := range b.Deals {
+		lines = append(lines, "", "優惠："+d.Title)
+		for _, opt := range d.Options {
+			price := opt.Price
+			if opt.OriginalPrice != "" && opt.OriginalPrice != price {
+				price += "（原價 " + opt.OriginalPrice + "）"
+			}
+			if opt.Title != "" {
+				price = opt.Title + " " + price
+			}
+			lines = append(lines, "・"+price)
+		}
+	}
+	for _, g := range b.GiftCertificates {
+		if len(g.Prices) > 0 {
+			lines = append(lines, "", "禮券："+strings.Join(g.Prices, "、"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// weekdays names PlaceHours.Day.
+var weekdays = []string{"週一", "週二", "週三", "週四", "週五", "週六", "週日"}
+
+// formatHours lists hours one line per day, as "週一 11:30–14:00、17:00–21:00".
+func formatHours(hours []PlaceHours) []string {
+	byDay := make([][]string, len(weekdays))
+	for _, h := range hours {
+		if h.Day < 0 || h.Day >= len(weekdays) {
+			continue
+		}
+		byDay[h.Day] = append(byDay[h.Day], formatClock(h.Start)+"–"+formatClock(h.End))
+	}
+	var lines []string
+	for day, spans := range byDay {
+		if len(spans) > 0 {
+			lines = append(lines, weekdays[day]+" "+strings.Join(spans, "、"))
+		}
+	}
+	return lines
+}
+
+// openAt reports whether hours has b open at now, a time in the place's own
+// zone. An opening whose End is not after its Start runs past midnight.
+func openAt(hours []PlaceHours, now time.Time) bool {
+	// Monday is 0 in PlaceHours and 1 in time.Weekday
+	today := (int(now.Weekday()) + 6) % 7
+	yesterday := (today + 6) % 7
+	clock := now.Format("1504")
+	for _, h := range hours {
+		overnight := h.End <= h.Start
+		switch {
+		case h.Day == today && clock >= h.Start && (overnight || clock < h.End):
+			return true
+		case h.Day == yesterday && overnight && clock < h.End:
+			return true
+		}
+	}
+	return false
+}
+
+// formatClock turns "1130" into "11:30".
+func formatClock(hhmm string) string {
+	if len(hhmm) != 4 {
+		return hhmm
+	}
+	return hhmm[:2] + ":" + hhmm[2:]
+}
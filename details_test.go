@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenAt(t *testing.T) {
+	hours := []PlaceHours{
+		{Day: 0, Start: "1130", End: "1400"},
+		{Day: 0, Start: "1700", End: "2100"},
+		{Day: 4, Start: "1800", End: "0200"},
+	}
+	// 2026-10-19 is a Monday
+	tests := []struct {
+		at   string
+		want bool
+	}{
+		{"2026-10-19 12:00", true},
+		{"2026-10-19 14:00", false},
+		{"2026-10-19 03:00", false},
+		{"2026-10-19 20:59", true},
+		{"2026-10-23 23:30", true},
+		{"2026-10-24 01:59", true},
+		{"2026-10-24 02:00", false},
+		{"2026-10-20 01:00", false},
+	}
+	for _, tt := range tests {
+		now, _ := time.Parse("2006-01-02 15:04", tt.at)
+		if got := openAt(hours, now); got != tt.want {
+			t.Errorf("openAt(%s) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestBusinessDetailsStatus(t *testing.T) {
+	monday3am := time.Date(2026, 10, 19, 3, 0, 0, 0, time.UTC)
+	lunch := []PlaceHours{{Day: 0, Start: "1130", End: "1400"}}
+	tests := []struct {
+		place Place
+		want  string
+	}{
+		{Place{Name: "a", IsClosed: true, Hours: lunch}, "狀態：已歇業"},
+		{Place{Name: "b", Hours: lunch}, "狀態：休息中"},
+		{Place{Name: "c", Hours: []PlaceHours{{Day: 0, Start: "0000", End: "0000"}}}, "狀態：營業中"},
+		{Place{Name: "d"}, ""},
+	}
+	for _, tt := range tests {
+		got := businessDetails(tt.place, monday3am)
+		if tt.want == "" {
+			if strings.Contains(got, "狀態") {
+				t.Errorf("%s without hours: %q", tt.place.Name, got)
+			}
+		} else if !strings.Contains(got, tt.want) {
+			t.Errorf("%s: %q, want %s", tt.place.Name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// DialogState names a step of the conversation.
+type DialogState string
+
+// DialogState constants
+const (
+	StateAwaitingFood     DialogState = "awaiting_food"
+	StateAwaitingLocation DialogState = "awaiting_location"
+	StateShowingResults   DialogState = "showing_results"
+	StateChoosingPlace    DialogState = "choosing_place"
+)
+
+// dialogContext is what a state handler gets to work with. Handlers may
+// change Session and add to Reply; the engine saves the one and sends the
+// other after the handler returns.
+type dialogContext struct {
+	User    string
+	Result  *linebot.ReceivedResult
+	Content *linebot.ReceivedContent
+	Session *Session
+	Places  PlaceProvider
+	Reply   *reply
+}
+
+// stateHandler reacts to the events that can arrive while the user is in one
+// state. Each handler returns the state to move to; a nil handler means the
+// event falls through to the dialog's fallback handler.
+type stateHandler struct {
+	OnText      func(c *dialogContext, text *linebot.ReceivedTextContent) DialogState
+	OnLocation  func(c *dialogContext, loc *linebot.ReceivedLocationContent) DialogState
+	OnSticker   func(c *dialogContext, sticker *linebot.ReceivedStickerContent) DialogState
+	OnOperation func(c *dialogContext, op *linebot.ReceivedOperation) DialogState
+}
+
+// textCommand handles a text message whatever state the user is in. It
+// reports false when the text is not meant for it.
+type textCommand func(c *dialogContext, text string) (next DialogState, handled bool)
+
+// dialog is a small state machine driving one conversation per user.
+type dialog struct {
+	initial     DialogState
+	states      map[DialogState]*stateHandler
+	transitions map[DialogState]map[DialogState]bool
+	fallback    *stateHandler
+	commands    []textCommand
+}
+
+func newDialog(initial DialogState) *dialog {
+	return &dialog{
+		initial:     initial,
+		states:      make(map[DialogState]*stateHandler),
+		transitions: make(map[DialogState]map[DialogState]bool),
+		fallback:    &stateHandler{},
+	}
+}
+
+// Handle registers the handlers for state.
+func (d *dialog) Handle(state DialogState, h *stateHandler) *dialog {
+	d.states[state] = h
+	return d
+}
+
+// Fallback registers the handlers used when a state has none for an event.
+func (d *dialog) Fallback(h *stateHandler) *dialog {
+	d.fallback = h
+	return d
+}
+
+// Command registers a text command. Commands are tried in order before the
+// state's own handlers.
+func (d *dialog) Command(cmd textCommand) *dialog {
+	d.commands = append(d.commands, cmd)
+	return d
+}
+
+// Allow declares the states that from may move to. Staying put and going
+// back to the initial state are always allowed.
+func (d *dialog) Allow(from DialogState, to ...DialogState) *dialog {
+	if d.transitions[from] == nil {
+		d.transitions[from] = make(map[DialogState]bool)
+	}
+	for _, s := range to {
+		d.transitions[from][s] = true
+	}
+	return d
+}
+
+func (d *dialog) allowed(from, to DialogState) bool {
+	return from == to || to == d.initial || d.transitions[from][to]
+}
+
+// Dispatch runs the handler for the user's current state and stores the
+// resulting session.
+func (d *dialog) Dispatch(c *dialogContext) error {
+	if c.Session.State == "" {
+		c.Session.State = d.initial
+	}
+	from := c.Session.State
+	h := d.states[from]
+	if h == nil {
+		h = d.fallback
+	}
+
+	next, handled, err := d.runCommands(c)
+	if err == nil && !handled {
+		next, handled, err = d.run(h, c)
+	}
+	if err == nil && !handled && h != d.fallback {
+		next, handled, err = d.run(d.fallback, c)
+	}
+	if sendErr := c.Reply.Send(); sendErr != nil {
+		log.Println(sendErr)
+	}
+	if err != nil {
+		return err
+	}
+	if !handled {
+		log.Printf("dialog: no handler in state %s for content type %d from %s", from, c.Content.ContentType, c.User)
+		return nil
+	}
+
+	if next == "" {
+		next = from
+	}
+	if !d.allowed(from, next) {
+		return fmt.Errorf("dialog: transition %s -> %s is not allowed", from, next)
+	}
+	// going back to the start leaves nothing worth keeping
+	if next == d.initial {
+		return sessions.Delete(c.User)
+	}
+	c.Session.State = next
+	c.Session.Updated = time.Time{}
+	return sessions.Put(c.User, *c.Session)
+}
+
+func (d *dialog) runCommands(c *dialogContext) (next DialogState, handled bool, err error) {
+	if len(d.commands) == 0 || !c.Content.IsMessage || c.Content.ContentType != linebot.ContentTypeText {
+		return "", false, nil
+	}
+	text, err := c.Content.TextContent()
+	if err != nil {
+		return "", false, err
+	}
+	for _, cmd := range d.commands {
+		if next, handled := cmd(c, text.Text); handled {
+			return next, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (d *dialog) run(h *stateHandler, c *dialogContext) (next DialogState, handled bool, err error) {
+	content := c.Content
+	switch {
+	case content.IsOperation:
+		if h.OnOperation == nil {
+			return "", false, nil
+		}
+		op, err := content.OperationContent()
+		if err != nil {
+			return "", false, err
+		}
+		return h.OnOperation(c, op), true, nil
+	case content.ContentType == linebot.ContentTypeText:
+		if h.OnText == nil {
+			return "", false, nil
+		}
+		text, err := content.TextContent()
+		if err != nil {
+			return "", false, err
+		}
+		return h.OnText(c, text), true, nil
+	case content.ContentType == linebot.ContentTypeLocation:
+		if h.OnLocation == nil {
+			return "", false, nil
+		}
+		loc, err := content.LocationContent()
+		if err != nil {
+			return "", false, err
+		}
+		return h.OnLocation(c, loc), true, nil
+	case content.ContentType == linebot.ContentTypeSticker:
+		if h.OnSticker == nil {
+			return "", false, nil
+		}
+		sticker, err := content.StickerContent()
+		if err != nil {
+			return "", false, err
+		}
+		return h.OnSticker(c, sticker), true, nil
+	}
+	return "", false, nil
+}
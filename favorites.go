@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxFavorites is how many places one user can save.
+const maxFavorites = 20
+
+const (
+	promptSaveHint      = "回覆「收藏 1」把第一家加入我的最愛"
+	promptSaved         = "已加入我的最愛！回覆「我的最愛」查看"
+	promptAlreadySaved  = "這家已經在我的最愛裡了！"
+	promptFavoritesFull = "我的最愛已經滿了，請先刪除一些"
+	promptNoFavorites   = "你的最愛還是空的！推薦餐廳後回覆「收藏 1」就能加入"
+	promptRemoveHint    = "回覆「刪除最愛 1」移除第一家"
+	promptRemoved       = "已從我的最愛移除！"
+	promptNoSuchPlace   = "找不到這一家，請確認號碼"
+)
+
+var (
+	saveFavoritePattern   = regexp.MustCompile(`(?i)^\s*(?:收藏|save)\s*([1-9])\s*$`)
+	removeFavoritePattern = regexp.MustCompile(`(?i)^\s*(?:刪除最愛|移除最愛|unsave|remove\s+favou?rite)\s*(\d+)\s*$`)
+)
+
+// favorite is a place a user saved. Only ID is authoritative; the rest is
+// what the provider said the last time we asked, kept for when it cannot be
+// reached.
+type favorite struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address,omitempty"`
+	Latitude  float64   `json:"latitude,omitempty"`
+	Longitude float64   `json:"longitude,omitempty"`
+	Closed    bool      `json:"closed,omitempty"`
+	Saved     time.Time `json:"saved"`
+}
+
+func newFavorite(b Place) favorite {
+	return favorite{
+		ID:        b.ID,
+		Name:      b.Name,
+		Address:   strings.Join(b.Address, ","),
+		Latitude:  b.Latitude,
+		Longitude: b.Longitude,
+		Closed:    b.IsClosed,
+	}
+}
+
+type favoriteRecord struct {
+	User     string    `json:"user"`
+	Favorite *favorite `json:"favorite,omitempty"`
+	Remove   string    `json:"remove,omitempty"`
+}
+
+// favoriteStore keeps every user's saved places, oldest first.
+type favoriteStore struct {
+	mu      sync.Mutex
+	users   map[string][]favorite
+	journal *journal
+}
+
+func newFavoriteStore() *favoriteStore {
+	return &favoriteStore{users: make(map[string][]favorite)}
+}
+
+// openFavoriteStore returns a favoriteStore journaled to path.
+func openFavoriteStore(path string) (*favoriteStore, error) {
+	s := newFavoriteStore()
+	j, err := openJournal(path, func(line []byte) error {
+		var r favoriteRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		s.apply(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := j.Compact(s.live()); err != nil {
+		j.Close()
+		return nil, err
+	}
+	s.journal = j
+	return s, nil
+}
+
+// apply changes the in-memory state for r. A favorite that is already saved
+// is updated in place.
+func (s *favoriteStore) apply(r favoriteRecord) {
+	list := s.users[r.User]
+	if r.Favorite != nil {
+		for i := range list {
+			if list[i].ID == r.Favorite.ID {
+				list[i] = *r.Favorite
+				return
+			}
+		}
+		s.users[r.User] = append(list, *r.Favorite)
+		return
+	}
+	for i := range list {
+		if list[i].ID == r.Remove {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(s.users, r.User)
+	} else {
+		s.users[r.User] = list
+	}
+}
+
+// live returns every saved place as a record. Callers hold s.mu, or own s
+// exclusively.
+func (s *favoriteStore) live() []interface{} {
+	var live []interface{}
+	for user, list := range s.users {
+		for i := range list {
+			live = append(live, favoriteRecord{User: user, Favorite: &list[i]})
+		}
+	}
+	return live
+}
+
+// record applies and journals r. Callers hold s.mu.
+func (s *favoriteStore) record(r favoriteRecord) {
+	s.apply(r)
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.Append(r); err != nil {
+		log.Println(err)
+	}
+	if err := s.journal.CompactIfDue(s.live); err != nil {
+		log.Println(err)
+	}
+}
+
+// errFavoritesFull and errAlreadySaved are why Add can refuse a place.
+var (
+	errFavoritesFull = fmt.Errorf("favorites: more than %d places", maxFavorites)
+	errAlreadySaved  = errors.New("favorites: already saved")
+)
+
+// Add saves f for user.
+func (s *favoriteStore) Add(user string, f favorite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.users[user]
+	for _, saved := range list {
+		if saved.ID == f.ID {
+			return errAlreadySaved
+		}
+	}
+	if len(list) >= maxFavorites {
+		return errFavoritesFull
+	}
+	f.Saved = time.Now()
+	s.record(favoriteRecord{User: user, Favorite: &f})
+	return nil
+}
+
+// Update replaces the details of a place user has saved, keeping when it was
+// saved. It does nothing if the place was removed meanwhile.
+func (s *favoriteStore) Update(user string, f favorite) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, saved := range s.users[user] {
+		if saved.ID == f.ID {
+			f.Saved = saved.Saved
+			if f != saved {
+				s.record(favoriteRecord{User: user, Favorite: &f})
+			}
+			return
+		}
+	}
+}
+
+// Remove forgets the place with id.
+func (s *favoriteStore) Remove(user, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record(favoriteRecord{User: user, Remove: id})
+}
+
+// Forget removes every place user saved.
+func (s *favoriteStore) Forget(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range append([]favorite(nil), s.users[user]...) {
+		s.record(favoriteRecord{User: user, Remove: f.ID})
+	}
+}
+
+// List returns a copy of user's favorites, oldest first.
+func (s *favoriteStore) List(user string) []favorite {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]favorite(nil), s.users[user]...)
+}
+
+// saveFavorite saves the business with id for user, asking provider for it
+// when it is no longer in recentBusinesses.
+func saveFavorite(provider PlaceProvider, user, id string) error {
+	b, ok := recentBusinesses.Get(id)
+	if !ok {
+		var err error
+		if b, err = provider.Details(id); err != nil {
+			return err
+		}
+	}
+	return favorites.Add(user, newFavorite(b))
+}
+
+func savedPrompt(err error) string {
+	switch err {
+	case nil:
+		return promptSaved
+	case errAlreadySaved:
+		return promptAlreadySaved
+	case errFavoritesFull:
+		return promptFavoritesFull
+	}
+	log.Println(err)
+	return promptNoSuchPlace
+}
+
+// favoritesCommand saves one of the last recommendations, lists the saved
+// places as location messages, and removes them.
+func favoritesCommand(c *dialogContext, text string) (DialogState, bool) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "我的最愛", "最愛", "favorites", "favourites":
+		listFavorites(c)
+		return "", true
+	}
+
+	if m := saveFavoritePattern.FindStringSubmatch(text); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		ids := history.LastTurn(c.User)
+		if n > len(ids) {
+			c.Reply.Text(promptNoSuchPlace)
+			return "", true
+		}
+		c.Reply.Text(savedPrompt(saveFavorite(c.Places, c.User, ids[n-1])))
+		return "", true
+	}
+
+	if m := removeFavoritePattern.FindStringSubmatch(text); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		list := favorites.List(c.User)
+		if n < 1 || n > len(list) {
+			c.Reply.Text(promptNoSuchPlace)
+			return "", true
+		}
+		favorites.Remove(c.User, list[n-1].ID)
+		c.Reply.Text(promptRemoved)
+		return "", true
+	}
+	return "", false
+}
+
+// listFavorites refreshes each saved place and adds it to the reply as a
+// numbered location. A place that cannot be looked up right now is listed as
+// it was last seen.
+func listFavorites(c *dialogContext) {
+	list := favorites.List(c.User)
+	if len(list) == 0 {
+		c.Reply.Text(promptNoFavorites)
+		return
+	}
+	for i, f := range list {
+		if b, err := c.Places.Details(f.ID); err == nil {
+			fresh := newFavorite(b)
+			favorites.Update(c.User, fresh)
+			f = fresh
+		} else {
+			log.Println(err)
+		}
+		title := strconv.Itoa(i+1) + ". " + f.Name
+		if f.Closed {
+			title += "（已歇業）"
+		}
+		c.Reply.Location(title, f.Address, f.Latitude, f.Longitude)
+	}
+	c.Reply.Text(promptRemoveHint)
+}
+
+// saveHandler answers the "save" button of a card. Only the first open of a
+// link that saved, or found the place saved, tells the user in LINE.
+func saveHandler(w http.ResponseWriter, r *http.Request) {
+	user, id, ok := actionParams(r)
+	if !ok {
+		w.WriteHeader(403)
+		return
+	}
+	if !claimAction("save", user, id) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		fmt.Fprintf(w, "<p>%s</p>", promptAlreadySaved)
+		return
+	}
+	err := saveFavorite(places, user, id)
+	if err != nil && err != errAlreadySaved {
+		// let the user try again, say once the list has room
+		releaseAction("save", user, id)
+	}
+	prompt := savedPrompt(err)
+	if err := newReply(user).Text(prompt).Send(); err != nil {
+		log.Println(err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	fmt.Fprintf(w, "<p>%s</p>", prompt)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// glyphs is a 5x7 bitmap font of printable ASCII. Each row uses the low
+// five bits, with bit 4 as the leftmost pixel; lower case descenders sit in
+// the last two rows. Runes without a glyph, such as Chinese, come from
+// wideGlyphs or are left out.
+var glyphs = map[rune][7]uint8{
+	' ':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	'!':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x00, 0x04},
+	'"':  {0x0a, 0x0a, 0x0a, 0x00, 0x00, 0x00, 0x00},
+	'#':  {0x0a, 0x0a, 0x1f, 0x0a, 0x1f, 0x0a, 0x0a},
+	'$':  {0x04, 0x0f, 0x14, 0x0e, 0x05, 0x1e, 0x04},
+	'%':  {0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03},
+	'&':  {0x0c, 0x12, 0x14, 0x08, 0x15, 0x12, 0x0d},
+	'\'': {0x04, 0x04, 0x08, 0x00, 0x00, 0x00, 0x00},
+	'(':  {0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02},
+	')':  {0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08},
+	'*':  {0x00, 0x04, 0x15, 0x0e, 0x15, 0x04, 0x00},
+	'+':  {0x00, 0x04, 0x04, 0x1f, 0x04, 0x04, 0x00},
+	',':  {0x00, 0x00, 0x00, 0x00, 0x0c, 0x04, 0x08},
+	'-':  {0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00},
+	'.':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c},
+	'/':  {0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00},
+	'0':  {0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	'1':  {0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'2':  {0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	'3':  {0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	'4':  {0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	'5':  {0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	'6':  {0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	'7':  {0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8':  {0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	'9':  {0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+	':':  {0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x0c, 0x00},
+	';':  {0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x04, 0x08},
+	'<':  {0x02, 0x04, 0x08, 0x10, 0x08, 0x04, 0x02},
+	'=':  {0x00, 0x00, 0x1f, 0x00, 0x1f, 0x00, 0x00},
+	'>':  {0x08, 0x04, 0x02, 0x01, 0x02, 0x04, 0x08},
+	'?':  {0x0e, 0x11, 0x01, 0x02, 0x04, 0x00, 0x04},
+	'@':  {0x0e, 0x11, 0x01, 0x0d, 0x15, 0x15, 0x0e},
+	'A':  {0x0e, 0x11, 0x11, 0x11, 0x1f, 0x11, 0x11},
+	'B':  {0x1e, 0x11, 0x11, 0x1e, 0x11, 0x11, 0x1e},
+	'C':  {0x0e, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0e},
+	'D':  {0x1c, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1c},
+	'E':  {0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x1f},
+	'F':  {0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x10},
+	'G':  {0x0e, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0f},
+	'H':  {0x11, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11},
+	'I':  {0x0e, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'J':  {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0c},
+	'K':  {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L':  {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1f},
+	'M':  {0x11, 0x1b, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N':  {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O':  {0x0e, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e},
+	'P':  {0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10, 0x10},
+	'Q':  {0x0e, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0d},
+	'R':  {0x1e, 0x11, 0x11, 0x1e, 0x14, 0x12, 0x11},
+	'S':  {0x0f, 0x10, 0x10, 0x0e, 0x01, 0x01, 0x1e},
+	'T':  {0x1f, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e},
+	'V':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x0a, 0x04},
+	'W':  {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0a},
+	'X':  {0x11, 0x11, 0x0a, 0x04, 0x0a, 0x11, 0x11},
+	'Y':  {0x11, 0x11, 0x11, 0x0a, 0x04, 0x04, 0x04},
+	'Z':  {0x1f, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1f},
+	'[':  {0x0e, 0x08, 0x08, 0x08, 0x08, 0x08, 0x0e},
+	'\\': {0x00, 0x10, 0x08, 0x04, 0x02, 0x01, 0x00},
+	']':  {0x0e, 0x02, 0x02, 0x02, 0x02, 0x02, 0x0e},
+	'^':  {0x04, 0x0a, 0x11, 0x00, 0x00, 0x00, 0x00},
+	'_':  {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1f},
+	'`':  {0x08, 0x04, 0x02, 0x00, 0x00, 0x00, 0x00},
+	'a':  {0x00, 0x00, 0x0e, 0x01, 0x0f, 0x11, 0x0f},
+	'b':  {0x10, 0x10, 0x16, 0x19, 0x11, 0x11, 0x1e},
+	'c':  {0x00, 0x00, 0x0e, 0x10, 0x10, 0x11, 0x0e},
+	'd':  {0x01, 0x01, 0x0d, 0x13, 0x11, 0x11, 0x0f},
+	'e':  {0x00, 0x00, 0x0e, 0x11, 0x1f, 0x10, 0x0e},
+	'f':  {0x06, 0x09, 0x08, 0x1c, 0x08, 0x08, 0x08},
+	'g':  {0x00, 0x0f, 0x11, 0x11, 0x0f, 0x01, 0x0e},
+	'h':  {0x10, 0x10, 0x16, 0x19, 0x11, 0x11, 0x11},
+	'i':  {0x04, 0x00, 0x0c, 0x04, 0x04, 0x04, 0x0e},
+	'j':  {0x02, 0x00, 0x06, 0x02, 0x02, 0x12, 0x0c},
+	'k':  {0x10, 0x10, 0x12, 0x14, 0x18, 0x14, 0x12},
+	'l':  {0x0c, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'm':  {0x00, 0x00, 0x1a, 0x15, 0x15, 0x11, 0x11},
+	'n':  {0x00, 0x00, 0x16, 0x19, 0x11, 0x11, 0x11},
+	'o':  {0x00, 0x00, 0x0e, 0x11, 0x11, 0x11, 0x0e},
+	'p':  {0x00, 0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10},
+	'q':  {0x00, 0x0f, 0x11, 0x11, 0x0f, 0x01, 0x01},
+	'r':  {0x00, 0x00, 0x16, 0x19, 0x10, 0x10, 0x10},
+	's':  {0x00, 0x00, 0x0e, 0x10, 0x0e, 0x01, 0x1e},
+	't':  {0x08, 0x08, 0x1c, 0x08, 0x08, 0x09, 0x06},
+	'u':  {0x00, 0x00, 0x11, 0x11, 0x11, 0x13, 0x0d},
+	'v':  {0x00, 0x00, 0x11, 0x11, 0x11, 0x0a, 0x04},
+	'w':  {0x00, 0x00, 0x11, 0x11, 0x15, 0x15, 0x0a},
+	'x':  {0x00, 0x00, 0x11, 0x0a, 0x04, 0x0a, 0x11},
+	'y':  {0x00, 0x11, 0x11, 0x11, 0x0f, 0x01, 0x0e},
+	'z':  {0x00, 0x00, 0x1f, 0x02, 0x04, 0x08, 0x1f},
+	'{':  {0x02, 0x04, 0x04, 0x08, 0x04, 0x04, 0x02},
+	'|':  {0x04, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'}':  {0x08, 0x04, 0x04, 0x02, 0x04, 0x04, 0x08},
+	'~':  {0x00, 0x00, 0x08, 0x15, 0x02, 0x00, 0x00},
+}
+
+const (
+	glyphWidth   = 5
+	glyphHeight  = 7
+	glyphAdvance = glyphWidth + 1
+)
+
+// wideGlyphs are 16 pixel high glyphs loaded from a GNU Unifont .hex file,
+// for the Chinese and other runes the bitmap font lacks. Each row uses the
+// top width bits, with bit 15 as the leftmost pixel.
+var wideGlyphs map[rune]wideGlyph
+
+type wideGlyph struct {
+	width int
+	rows  [16]uint16
+}
+
+// wideGlyphHeight is how many font pixels a wide glyph is drawn over: a
+// little taller than the bitmap font, so strokes stay legible.
+const wideGlyphHeight = glyphHeight + 2
+
+// loadHexFont reads the glyphs beyond ASCII from a .hex file, whose lines
+// look like "9F0E:0000...". Both 8 and 16 pixel wide glyphs are kept.
+func loadHexFont(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[rune]wideGlyph)
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return fmt.Errorf("%s:%d: bad glyph", path, n+1)
+		}
+		code, err := strconv.ParseUint(line[:i], 16, 32)
+		if err != nil {
+			return fmt.Errorf("%s:%d: bad glyph", path, n+1)
+		}
+		if code < 0x80 {
+			continue
+		}
+		bits, err := hex.DecodeString(line[i+1:])
+		if err != nil || (len(bits) != 16 && len(bits) != 32) {
+			return fmt.Errorf("%s:%d: bad glyph", path, n+1)
+		}
+		g := wideGlyph{width: len(bits) / 2}
+		for row := range g.rows {
+			if g.width == 8 {
+				g.rows[row] = uint16(bits[row]) << 8
+			} else {
+				g.rows[row] = uint16(bits[2*row])<<8 | uint16(bits[2*row+1])
+			}
+		}
+		loaded[rune(code)] = g
+	}
+	wideGlyphs = loaded
+	return nil
+}
+
+func canDraw(r rune) bool {
+	if _, ok := glyphs[r]; ok {
+		return true
+	}
+	_, ok := wideGlyphs[r]
+	return ok
+}
+
+// drawable returns text without the runes the fonts cannot draw, with the
+// spaces that leaves behind collapsed.
+func drawable(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if canDraw(r) {
+			b.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// runeAdvance is how far drawing r at scale moves along.
+func runeAdvance(r rune, scale int) int {
+	if _, ok := glyphs[r]; ok {
+		return glyphAdvance * scale
+	}
+	if g, ok := wideGlyphs[r]; ok {
+		return g.width*wideGlyphHeight*scale/16 + scale
+	}
+	return 0
+}
+
+// textWidth returns how wide text is when drawn at scale.
+func textWidth(text string, scale int) int {
+	w := 0
+	for _, r := range text {
+		w += runeAdvance(r, scale)
+	}
+	if w == 0 {
+		return 0
+	}
+	return w - scale
+}
+
+// drawText draws text with its top left corner at (x, y), each font pixel
+// being scale by scale screen pixels. Wide glyphs are scaled to stand a
+// pixel above and below the bitmap font.
+func drawText(dst draw.Image, x, y, scale int, c color.Color, text string) {
+	src := image.NewUniform(c)
+	for _, r := range text {
+		if g, ok := glyphs[r]; ok {
+			for row := 0; row < glyphHeight; row++ {
+				for col := 0; col < glyphWidth; col++ {
+					if g[row]&(1<<uint(glyphWidth-1-col)) == 0 {
+						continue
+					}
+					px := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+					draw.Draw(dst, px, src, image.Point{}, draw.Src)
+				}
+			}
+		} else if g, ok := wideGlyphs[r]; ok {
+			h := wideGlyphHeight * scale
+			top := y - scale
+			for row := 0; row < 16; row++ {
+				for col := 0; col < g.width; col++ {
+					if g.rows[row]&(1<<uint(15-col)) == 0 {
+						continue
+					}
+					px := image.Rect(x+col*h/16, top+row*h/16, x+(col+1)*h/16, top+(row+1)*h/16)
+					draw.Draw(dst, px, src, image.Point{}, draw.Src)
+				}
+			}
+		}
+		x += runeAdvance(r, scale)
+	}
+}
+
+// fitText drops what the font cannot draw and shortens the rest with an
+// ellipsis until it is at most width wide at scale.
+func fitText(text string, scale, width int) string {
+	text = drawable(text)
+	if textWidth(text, scale) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes)+"...", scale) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "..."
+}
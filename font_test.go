@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestGlyphsCoverPrintableASCII(t *testing.T) {
+	for r := rune(' '); r <= '~'; r++ {
+		if _, ok := glyphs[r]; !ok {
+			t.Errorf("no glyph for %q", r)
+		}
+	}
+	for r := 'a'; r <= 'z'; r++ {
+		if glyphs[r] == glyphs[r-'a'+'A'] {
+			t.Errorf("%q is drawn as upper case", r)
+		}
+	}
+}
+
+func TestCardTitleFallback(t *testing.T) {
+	saved := wideGlyphs
+	defer func() { wideGlyphs = saved }()
+	wideGlyphs = nil
+
+	tests := []struct {
+		place Place
+		want  string
+	}{
+		{Place{Name: "Pho House 越南河粉"}, "Pho House"},
+		{Place{Name: "一蘭拉麵", Categories: []PlaceCategory{{Name: "Ramen", Alias: "ramen"}}}, "Ramen"},
+		{Place{Name: "一蘭拉麵"}, "RESTAURANT"},
+	}
+	for _, tt := range tests {
+		if got := cardTitle(tt.place); got != tt.want {
+			t.Errorf("cardTitle(%q) = %q, want %q", tt.place.Name, got, tt.want)
+		}
+	}
+
+	wideGlyphs = map[rune]wideGlyph{'一': {width: 16}, '蘭': {width: 16}}
+	if got := cardTitle(Place{Name: "一蘭拉麵"}); got != "一蘭" {
+		t.Errorf("with a font loaded, cardTitle = %q", got)
+	}
+}
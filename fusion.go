@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/guregu/null"
+)
+
+// fusionBaseURL is where the Yelp Fusion (v3) API lives.
+const fusionBaseURL = "https://api.yelp.com/v3"
+
+// fusionClient talks to the Yelp Fusion API with an API key as the bearer
+// token. baseURL can point at a fake server.
+type fusionClient struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+func newFusionClient(baseURL, apiKey string) *fusionClient {
+	if baseURL == "" {
+		baseURL = fusionBaseURL
+	}
+	return &fusionClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// fusionError is an error answer from the API, such as BUSINESS_NOT_FOUND.
+type fusionError struct {
+	Status      int
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+func (e *fusionError) Error() string {
+	return fmt.Sprintf("yelp fusion: %d %s: %s", e.Status, e.Code, e.Description)
+}
+
+// fusionSearch is a business search. It needs a location, or a latitude and
+// longitude. Zero fields are left out of the request.
+type fusionSearch struct {
+	Term       string
+	Location   string
+	Latitude   null.Float
+	Longitude  null.Float
+	Categories []string
+	// Radius is in meters, at most 40000.
+	Radius int
+	// Price lists the price levels, 1 to 4, to keep.
+	Price []int
+	// OpenNow and OpenAt cannot both be set.
+	OpenNow bool
+	OpenAt  time.Time
+	// Attributes are extra filters such as "deals".
+	Attributes []string
+	// SortBy is one of best_match, rating, review_count and distance.
+	SortBy string
+	Limit  int
+	Offset int
+	Locale string
+}
+
+func (s fusionSearch) values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("term", s.Term)
+	set("location", s.Location)
+	if s.Latitude.Valid && s.Longitude.Valid {
+		v.Set("latitude", strconv.FormatFloat(s.Latitude.Float64, 'f', -1, 64))
+		v.Set("longitude", strconv.FormatFloat(s.Longitude.Float64, 'f', -1, 64))
+	}
+	set("categories", strings.Join(s.Categories, ","))
+	if s.Radius > 0 {
+		v.Set("radius", strconv.Itoa(s.Radius))
+	}
+	var prices []string
+	for _, p := range s.Price {
+		prices = append(prices, strconv.Itoa(p))
+	}
+	set("price", strings.Join(prices, ","))
+	if s.OpenNow {
+		v.Set("open_now", "true")
+	}
+	if !s.OpenAt.IsZero() {
+		v.Set("open_at", strconv.FormatInt(s.OpenAt.Unix(), 10))
+	}
+	set("attributes", strings.Join(s.Attributes, ","))
+	set("sort_by", s.SortBy)
+	if s.Limit > 0 {
+		v.Set("limit", strconv.Itoa(s.Limit))
+	}
+	if s.Offset > 0 {
+		v.Set("offset", strconv.Itoa(s.Offset))
+	}
+	set("locale", s.Locale)
+	return v
+}
+
+type fusionSearchResponse struct {
+	Total      int              `json:"total"`
+	Businesses []fusionBusiness `json:"businesses"`
+}
+
+// fusionBusiness is a business as search and details return it. Hours and
+// Photos only come with details.
+type fusionBusiness struct {
+	ID           string           `json:"id"`
+	Alias        string           `json:"alias"`
+	Name         string           `json:"name"`
+	ImageURL     string           `json:"image_url"`
+	IsClosed     bool             `json:"is_closed"`
+	URL          string           `json:"url"`
+	Phone        string           `json:"phone"`
+	DisplayPhone string           `json:"display_phone"`
+	ReviewCount  int              `json:"review_count"`
+	Categories   []fusionCategory `json:"categories"`
+	Rating       float64          `json:"rating"`
+	Coordinates  struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"coordinates"`
+	// Price is dollar signs, such as "$$".
+	Price    string `json:"price"`
+	Location struct {
+		Address1       string   `json:"address1"`
+		City           string   `json:"city"`
+		DisplayAddress []string `json:"display_address"`
+		CrossStreets   string   `json:"cross_streets"`
+	} `json:"location"`
+	// Distance is in meters from where a search was made.
+	Distance float64       `json:"distance"`
+	Photos   []string      `json:"photos"`
+	Hours    []fusionHours `json:"hours"`
+}
+
+type fusionCategory struct {
+	Alias string `json:"alias"`
+	Title string `json:"title"`
+}
+
+type fusionHours struct {
+	HoursType string       `json:"hours_type"`
+	IsOpenNow bool         `json:"is_open_now"`
+	Open      []fusionOpen `json:"open"`
+}
+
+// fusionOpen is one opening on Day, 0 being Monday. Start and End are local
+// times written as "1130".
+type fusionOpen struct {
+	Day         int    `json:"day"`
+	Start       string `json:"start"`
+	End         string `json:"end"`
+	IsOvernight bool   `json:"is_overnight"`
+}
+
+type fusionReview struct {
+	ID          string  `json:"id"`
+	Rating      float64 `json:"rating"`
+	Text        string  `json:"text"`
+	URL         string  `json:"url"`
+	TimeCreated string  `json:"time_created"`
+	User        struct {
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+// fusionAutocomplete suggests search terms, businesses and categories for
+// what a user has typed so far.
+type fusionAutocomplete struct {
+	Terms []struct {
+		Text string `json:"text"`
+	} `json:"terms"`
+	Businesses []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"businesses"`
+	Categories []fusionCategory `json:"categories"`
+}
+
+// Search runs a business search.
+func (c *fusionClient) Search(s fusionSearch) (fusionSearchResponse, error) {
+	var r fusionSearchResponse
+	err := c.get("/businesses/search", s.values(), &r)
+	return r, err
+}
+
+// Business looks up one business with its photos and hours.
+func (c *fusionClient) Business(id, locale string) (fusionBusiness, error) {
+	v := url.Values{}
+	if locale != "" {
+		v.Set("locale", locale)
+	}
+	var b fusionBusiness
+	err := c.get("/businesses/"+url.PathEscape(id), v, &b)
+	return b, err
+}
+
+// Reviews returns the review excerpts Yelp shares for a business, at most
+// three.
+func (c *fusionClient) Reviews(id, locale string) ([]fusionReview, error) {
+	v := url.Values{}
+	if locale != "" {
+		v.Set("locale", locale)
+	}
+	var r struct {
+		Reviews []fusionReview `json:"reviews"`
+	}
+	err := c.get("/businesses/"+url.PathEscape(id)+"/reviews", v, &r)
+	return r.Reviews, err
+}
+
+// Autocomplete completes text, near latitude and longitude when they are
+// set.
+func (c *fusionClient) Autocomplete(text string, latitude, longitude null.Float, locale string) (fusionAutocomplete, error) {
+	v := url.Values{"text": {text}}
+	if latitude.Valid && longitude.Valid {
+		v.Set("latitude", strconv.FormatFloat(latitude.Float64, 'f', -1, 64))
+		v.Set("longitude", strconv.FormatFloat(longitude.Float64, 'f', -1, 64))
+	}
+	if locale != "" {
+		v.Set("locale", locale)
+	}
+	var a fusionAutocomplete
+	err := c.get("/autocomplete", v, &a)
+	return a, err
+}
+
+// get decodes the JSON answer to a GET of path into v, or the error the API
+// answered with into a *fusionError.
+func (c *fusionClient) get(path string, query url.Values, v interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error *fusionError `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) != nil || body.Error == nil {
+			body.Error = &fusionError{Description: resp.Status}
+		}
+		body.Error.Status = resp.StatusCode
+		return body.Error
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/guregu/null"
+)
+
+// fakeFusion answers like the Fusion API for one business, "pho-house".
+func fakeFusion(t *testing.T, requests *[]*http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		switch r.URL.Path {
+		case "/businesses/search":
+			if r.URL.Query().Get("location") == "nowhere" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error": {"code": "LOCATION_NOT_FOUND", "description": "Could not execute search, try specifying a more exact location."}}`)
+				return
+			}
+			fmt.Fprint(w, `{"total": 31, "businesses": [{
+				"id": "pho-house", "name": "Pho House", "price": "$$", "rating": 4.5,
+				"review_count": 120, "distance": 321.5, "phone": "+886227000000",
+				"url": "https://www.yelp.com/biz/pho-house", "image_url": "https://example.com/o.jpg",
+				"coordinates": {"latitude": 25.04, "longitude": 121.56},
+				"categories": [{"alias": "vietnamese", "title": "Vietnamese"}],
+				"location": {"display_address": ["松仁路100號", "台北市信義區"]}
+			}]}`)
+		case "/businesses/pho-house":
+			fmt.Fprint(w, `{"id": "pho-house", "name": "Pho House", "hours": [
+				{"hours_type": "REGULAR", "is_open_now": true, "open": [
+					{"day": 0, "start": "1130", "end": "1400", "is_overnight": false},
+					{"day": 0, "start": "1700", "end": "2100", "is_overnight": false}
+				]}
+			]}`)
+		case "/businesses/pho-house/reviews":
+			fmt.Fprint(w, `{"total": 1, "reviews": [{"rating": 5, "text": "Great broth.", "user": {"name": "Amy"}}]}`)
+		case "/autocomplete":
+			fmt.Fprint(w, `{"terms": [{"text": "pho"}], "categories": [{"alias": "vietnamese", "title": "Vietnamese"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"code": "BUSINESS_NOT_FOUND", "description": "The requested business could not be found."}}`)
+		}
+	}))
+}
+
+func TestFusionSearch(t *testing.T) {
+	var requests []*http.Request
+	server := fakeFusion(t, &requests)
+	defer server.Close()
+	provider := newFusionProvider(newFusionClient(server.URL, "secret"), "zh_TW")
+
+	openAt := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	results, err := provider.SearchByCoordinate(PlaceQuery{
+		Term:     "pho",
+		Radius:   null.FloatFrom(800),
+		Sort:     null.IntFrom(sortHighestRate),
+		Deals:    null.BoolFrom(true),
+		OpenAt:   openAt,
+		MaxPrice: 2,
+		Limit:    20,
+		Offset:   20,
+	}, 25.03, 121.55)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := requests[0].URL.Query()
+	want := map[string]string{
+		"term":       "pho",
+		"latitude":   "25.03",
+		"longitude":  "121.55",
+		"radius":     "800",
+		"sort_by":    "rating",
+		"attributes": "deals",
+		"open_at":    fmt.Sprint(openAt.Unix()),
+		"price":      "1,2",
+		"limit":      "20",
+		"offset":     "20",
+		"locale":     "zh_TW",
+	}
+	for key, value := range want {
+		if got.Get(key) != value {
+			t.Errorf("%s = %q, want %q", key, got.Get(key), value)
+		}
+	}
+	if got.Get("open_now") != "" {
+		t.Errorf("open_now sent along with open_at")
+	}
+
+	if results.Total != 31 || len(results.Places) != 1 {
+		t.Fatalf("got %d of %d places", len(results.Places), results.Total)
+	}
+	p := results.Places[0]
+	if p.ID != "pho-house" || p.Price != 2 || p.Rating != 4.5 || p.ReviewCount != 120 || p.Distance != 321.5 {
+		t.Errorf("place = %+v", p)
+	}
+	if p.Latitude != 25.04 || p.Longitude != 121.56 || len(p.Address) != 2 {
+		t.Errorf("location = %v,%v %v", p.Latitude, p.Longitude, p.Address)
+	}
+	if aliases := p.CategoryAliases(); len(aliases) != 1 || aliases[0] != "vietnamese" {
+		t.Errorf("categories = %v", aliases)
+	}
+}
+
+func TestFusionSearchByText(t *testing.T) {
+	var requests []*http.Request
+	server := fakeFusion(t, &requests)
+	defer server.Close()
+	provider := newFusionProvider(newFusionClient(server.URL, "secret"), "")
+
+	hint := &LatLng{Latitude: 25.03, Longitude: 121.56}
+	if _, err := provider.SearchByText(PlaceQuery{}, "台北市信義區松仁路100號", hint); err != nil {
+		t.Fatal(err)
+	}
+	got := requests[0].URL.Query()
+	if got.Get("location") != "台北市信義區松仁路100號" {
+		t.Errorf("location = %q", got.Get("location"))
+	}
+	if got.Get("term") != defaultFood {
+		t.Errorf("term = %q, want %q", got.Get("term"), defaultFood)
+	}
+
+	if _, err := provider.SearchByText(PlaceQuery{}, "nowhere", nil); err != errUnknownLocation {
+		t.Errorf("unknown location: err = %v", err)
+	}
+}
+
+func TestFusionDetails(t *testing.T) {
+	var requests []*http.Request
+	server := fakeFusion(t, &requests)
+	defer server.Close()
+	provider := newFusionProvider(newFusionClient(server.URL, "secret"), "")
+
+	p, err := provider.Details("pho-house")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Hours) != 2 || p.Hours[0] != (PlaceHours{Day: 0, Start: "1130", End: "1400"}) {
+		t.Errorf("hours = %+v", p.Hours)
+	}
+	if len(p.Reviews) != 1 || p.Reviews[0].Excerpt != "Great broth." || p.Reviews[0].Author != "Amy" || p.Reviews[0].Rating != 5 {
+		t.Errorf("reviews = %+v", p.Reviews)
+	}
+
+	if _, err := provider.Details("gone"); err != errPlaceNotFound {
+		t.Errorf("unknown business: err = %v", err)
+	}
+}
+
+func TestFusionReviews(t *testing.T) {
+	var requests []*http.Request
+	server := fakeFusion(t, &requests)
+	defer server.Close()
+	client := newFusionClient(server.URL, "secret")
+
+	reviews, err := client.Reviews("pho-house", "en_US")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reviews) != 1 || reviews[0].User.Name != "Amy" {
+		t.Errorf("reviews = %+v", reviews)
+	}
+	if got := requests[0].URL.Query().Get("locale"); got != "en_US" {
+		t.Errorf("locale = %q", got)
+	}
+
+	_, err = client.Reviews("gone", "")
+	if e, ok := err.(*fusionError); !ok || e.Status != http.StatusNotFound || e.Code != "BUSINESS_NOT_FOUND" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestFusionSuggestTerms(t *testing.T) {
+	var requests []*http.Request
+	server := fakeFusion(t, &requests)
+	defer server.Close()
+	provider := newFusionProvider(newFusionClient(server.URL, "secret"), "")
+
+	terms, err := provider.SuggestTerms("ph", &LatLng{Latitude: 25.03, Longitude: 121.55})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(terms) != 2 || terms[0] != "pho" || terms[1] != "Vietnamese" {
+		t.Errorf("terms = %v", terms)
+	}
+	got := requests[0].URL.Query()
+	if got.Get("text") != "ph" || got.Get("latitude") != "25.03" {
+		t.Errorf("query = %v", got)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/guregu/null"
+)
+
+// fusionSortBy maps the sort modes to Yelp Fusion's sort_by.
+var fusionSortBy = map[int64]string{
+	sortBestMatched: "best_match",
+	sortDistance:    "distance",
+	sortHighestRate: "rating",
+}
+
+// fusionProvider is a PlaceProvider over the Yelp Fusion (v3) API.
+type fusionProvider struct {
+	client *fusionClient
+	locale string
+}
+
+func newFusionProvider(client *fusionClient, locale string) *fusionProvider {
+	return &fusionProvider{client: client, locale: locale}
+}
+
+func (f *fusionProvider) SearchByCoordinate(q PlaceQuery, latitude, longitude float64) (PlaceResults, error) {
+	s := f.searchOptions(q)
+	s.Latitude = null.FloatFrom(latitude)
+	s.Longitude = null.FloatFrom(longitude)
+	return f.search(s)
+}
+
+// SearchByText searches around location. Fusion has no hint parameter and
+// prefers a location over coordinates, so hint is only used when location
+// is empty.
+func (f *fusionProvider) SearchByText(q PlaceQuery, location string, hint *LatLng) (PlaceResults, error) {
+	s := f.searchOptions(q)
+	s.Location = location
+	if location == "" && hint != nil {
+		s.Latitude = null.FloatFrom(hint.Latitude)
+		s.Longitude = null.FloatFrom(hint.Longitude)
+	}
+	results, err := f.search(s)
+	if e, ok := err.(*fusionError); ok && e.Code == "LOCATION_NOT_FOUND" {
+		return PlaceResults{}, errUnknownLocation
+	}
+	return results, err
+}
+
+func (f *fusionProvider) search(s fusionSearch) (PlaceResults, error) {
+	result, err := f.client.Search(s)
+	if err != nil {
+		return PlaceResults{}, err
+	}
+	places := make([]Place, len(result.Businesses))
+	for i, b := range result.Businesses {
+		places[i] = fusionPlace(b)
+	}
+	return PlaceResults{Total: result.Total, Places: places}, nil
+}
+
+// Details combines the business with its reviews. Missing reviews are only
+// logged, as the rest is still worth showing.
+func (f *fusionProvider) Details(id string) (Place, error) {
+	b, err := f.client.Business(id, f.locale)
+	if err != nil {
+		if e, ok := err.(*fusionError); ok && (e.Code == "BUSINESS_NOT_FOUND" || e.Status == http.StatusNotFound) {
+			return Place{}, errPlaceNotFound
+		}
+		return Place{}, err
+	}
+	p := fusionPlace(b)
+	reviews, err := f.client.Reviews(id, f.locale)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, r := range reviews {
+		p.Reviews = append(p.Reviews, PlaceReview{
+			Rating:  r.Rating,
+			Excerpt: r.Text,
+			Author:  r.User.Name,
+		})
+	}
+	return p, nil
+}
+
+func (f *fusionProvider) searchOptions(q PlaceQuery) fusionSearch {
+	s := fusionSearch{
+		Term:       q.Term,
+		Categories: q.Categories,
+		OpenNow:    q.OpenNow,
+		Limit:      q.Limit,
+		Offset:     q.Offset,
+		Locale:     f.locale,
+	}
+	if s.Term == "" && len(s.Categories) == 0 {
+		s.Term = defaultFood
+	}
+	if q.Radius.Valid {
+		s.Radius = int(q.Radius.Float64)
+		if s.Radius > maxRadius {
+			s.Radius = maxRadius
+		}
+	}
+	if q.Sort.Valid {
+		s.SortBy = fusionSortBy[q.Sort.Int64]
+	}
+	if q.Deals.Valid && q.Deals.Bool {
+		s.Attributes = []string{"deals"}
+	}
+	if !q.OpenNow {
+		s.OpenAt = q.OpenAt
+	}
+	for price := 1; price <= q.MaxPrice && price <= 4; price++ {
+		s.Price = append(s.Price, price)
+	}
+	return s
+}
+
+// SuggestTerms completes text with Fusion's autocomplete, offering its
+// terms and then its categories.
+func (f *fusionProvider) SuggestTerms(text string, near *LatLng) ([]string, error) {
+	var latitude, longitude null.Float
+	if near != nil {
+		latitude, longitude = null.FloatFrom(near.Latitude), null.FloatFrom(near.Longitude)
+	}
+	a, err := f.client.Autocomplete(text, latitude, longitude, f.locale)
+	if err != nil {
+		return nil, err
+	}
+	var terms []string
+	for _, t := range a.Terms {
+		terms = append(terms, t.Text)
+	}
+	for _, c := range a.Categories {
+		if !containsString(terms, c.Title) {
+			terms = append(terms, c.Title)
+		}
+	}
+	return terms, nil
+}
+
+func fusionPlace(b fusionBusiness) Place {
+	p := Place{
+		ID:           b.ID,
+		Name:         b.Name,
+		URL:          b.URL,
+		PhotoURL:     b.ImageURL,
+		Phone:        b.Phone,
+		Rating:       b.Rating,
+		ReviewCount:  b.ReviewCount,
+		Price:        strings.Count(b.Price, "$"),
+		Distance:     b.Distance,
+		Address:      b.Location.DisplayAddress,
+		Latitude:     b.Coordinates.Latitude,
+		Longitude:    b.Coordinates.Longitude,
+		IsClosed:     b.IsClosed,
+		CrossStreets: b.Location.CrossStreets,
+	}
+	for _, c := range b.Categories {
+		p.Categories = append(p.Categories, PlaceCategory{Name: c.Title, Alias: c.Alias})
+	}
+	for _, h := range b.Hours {
+		if h.HoursType != "" && h.HoursType != "REGULAR" {
+			continue
+		}
+		for _, o := range h.Open {
+			p.Hours = append(p.Hours, PlaceHours{Day: o.Day, Start: o.Start, End: o.End})
+		}
+	}
+	return p
+}
@@ -0,0 +1,132 @@
+package main
+
+// taiwanPlaces is the gazetteer bundled with the bot: every city and county,
+// the districts whose names come up in more than one city or are asked for
+// most, and well-known landmarks.
+var taiwanPlaces = []gazetteerEntry{
+	// cities and counties
+	{Name: "台北市", Kind: kindCity, Aliases: []string{"taipei"}, Latitude: 25.0375, Longitude: 121.5637},
+	{Name: "新北市", Kind: kindCity, Aliases: []string{"new taipei"}, Latitude: 25.0120, Longitude: 121.4657},
+	{Name: "桃園市", Kind: kindCity, Aliases: []string{"taoyuan"}, Latitude: 24.9936, Longitude: 121.3010},
+	{Name: "台中市", Kind: kindCity, Aliases: []string{"taichung"}, Latitude: 24.1477, Longitude: 120.6736},
+	{Name: "台南市", Kind: kindCity, Aliases: []string{"tainan"}, Latitude: 22.9999, Longitude: 120.2270},
+	{Name: "高雄市", Kind: kindCity, Aliases: []string{"kaohsiung"}, Latitude: 22.6273, Longitude: 120.3014},
+	{Name: "基隆市", Kind: kindCity, Aliases: []string{"keelung"}, Latitude: 25.1276, Longitude: 121.7392},
+	{Name: "新竹市", Kind: kindCity, Aliases: []string{"hsinchu"}, Latitude: 24.8138, Longitude: 120.9675},
+	{Name: "嘉義市", Kind: kindCity, Aliases: []string{"chiayi"}, Latitude: 23.4801, Longitude: 120.4491},
+	{Name: "新竹縣", Kind: kindCity, Latitude: 24.8387, Longitude: 121.0177},
+	{Name: "苗栗縣", Kind: kindCity, Aliases: []string{"miaoli"}, Latitude: 24.5602, Longitude: 120.8214},
+	{Name: "彰化縣", Kind: kindCity, Aliases: []string{"changhua"}, Latitude: 24.0518, Longitude: 120.5161},
+	{Name: "南投縣", Kind: kindCity, Aliases: []string{"nantou"}, Latitude: 23.9609, Longitude: 120.9719},
+	{Name: "雲林縣", Kind: kindCity, Aliases: []string{"yunlin"}, Latitude: 23.7092, Longitude: 120.4313},
+	{Name: "嘉義縣", Kind: kindCity, Latitude: 23.4518, Longitude: 120.2555},
+	{Name: "屏東縣", Kind: kindCity, Aliases: []string{"pingtung"}, Latitude: 22.5519, Longitude: 120.5487},
+	{Name: "宜蘭縣", Kind: kindCity, Aliases: []string{"yilan"}, Latitude: 24.7021, Longitude: 121.7378},
+	{Name: "花蓮縣", Kind: kindCity, Aliases: []string{"hualien"}, Latitude: 23.9872, Longitude: 121.6016},
+	{Name: "台東縣", Kind: kindCity, Aliases: []string{"taitung"}, Latitude: 22.7583, Longitude: 121.1444},
+	{Name: "澎湖縣", Kind: kindCity, Aliases: []string{"penghu"}, Latitude: 23.5711, Longitude: 119.5793},
+	{Name: "金門縣", Kind: kindCity, Aliases: []string{"kinmen"}, Latitude: 24.4493, Longitude: 118.3767},
+	{Name: "連江縣", Kind: kindCity, Aliases: []string{"matsu", "馬祖"}, Latitude: 26.1608, Longitude: 119.9517},
+
+	// 台北市
+	{Name: "中正區", City: "台北市", Kind: kindDistrict, Latitude: 25.0324, Longitude: 121.5199},
+	{Name: "大同區", City: "台北市", Kind: kindDistrict, Latitude: 25.0633, Longitude: 121.5130},
+	{Name: "中山區", City: "台北市", Kind: kindDistrict, Latitude: 25.0640, Longitude: 121.5332},
+	{Name: "松山區", City: "台北市", Kind: kindDistrict, Latitude: 25.0500, Longitude: 121.5775},
+	{Name: "大安區", City: "台北市", Kind: kindDistrict, Latitude: 25.0268, Longitude: 121.5434},
+	{Name: "萬華區", City: "台北市", Kind: kindDistrict, Latitude: 25.0286, Longitude: 121.4979},
+	{Name: "信義區", City: "台北市", Kind: kindDistrict, Latitude: 25.0330, Longitude: 121.5654},
+	{Name: "士林區", City: "台北市", Kind: kindDistrict, Latitude: 25.0928, Longitude: 121.5246},
+	{Name: "北投區", City: "台北市", Kind: kindDistrict, Latitude: 25.1321, Longitude: 121.4987},
+	{Name: "內湖區", City: "台北市", Kind: kindDistrict, Latitude: 25.0697, Longitude: 121.5886},
+	{Name: "南港區", City: "台北市", Kind: kindDistrict, Latitude: 25.0546, Longitude: 121.6066},
+	{Name: "文山區", City: "台北市", Kind: kindDistrict, Latitude: 24.9897, Longitude: 121.5703},
+
+	// 新北市
+	{Name: "板橋區", City: "新北市", Kind: kindDistrict, Latitude: 25.0115, Longitude: 121.4627},
+	{Name: "三重區", City: "新北市", Kind: kindDistrict, Latitude: 25.0615, Longitude: 121.4880},
+	{Name: "中和區", City: "新北市", Kind: kindDistrict, Latitude: 24.9994, Longitude: 121.4987},
+	{Name: "永和區", City: "新北市", Kind: kindDistrict, Latitude: 25.0077, Longitude: 121.5165},
+	{Name: "新莊區", City: "新北市", Kind: kindDistrict, Latitude: 25.0360, Longitude: 121.4508},
+	{Name: "新店區", City: "新北市", Kind: kindDistrict, Latitude: 24.9676, Longitude: 121.5420},
+	{Name: "淡水區", City: "新北市", Kind: kindDistrict, Latitude: 25.1696, Longitude: 121.4407},
+	{Name: "汐止區", City: "新北市", Kind: kindDistrict, Latitude: 25.0629, Longitude: 121.6420},
+	{Name: "土城區", City: "新北市", Kind: kindDistrict, Latitude: 24.9722, Longitude: 121.4437},
+	{Name: "蘆洲區", City: "新北市", Kind: kindDistrict, Latitude: 25.0847, Longitude: 121.4736},
+
+	// 基隆市
+	{Name: "中正區", City: "基隆市", Kind: kindDistrict, Latitude: 25.1443, Longitude: 121.7687},
+	{Name: "信義區", City: "基隆市", Kind: kindDistrict, Latitude: 25.1292, Longitude: 121.7517},
+	{Name: "中山區", City: "基隆市", Kind: kindDistrict, Latitude: 25.1480, Longitude: 121.7310},
+	{Name: "仁愛區", City: "基隆市", Kind: kindDistrict, Latitude: 25.1270, Longitude: 121.7402},
+
+	// 台中市
+	{Name: "中區", City: "台中市", Kind: kindDistrict, Latitude: 24.1416, Longitude: 120.6807},
+	{Name: "東區", City: "台中市", Kind: kindDistrict, Latitude: 24.1368, Longitude: 120.6968},
+	{Name: "西區", City: "台中市", Kind: kindDistrict, Latitude: 24.1413, Longitude: 120.6640},
+	{Name: "南區", City: "台中市", Kind: kindDistrict, Latitude: 24.1213, Longitude: 120.6630},
+	{Name: "北區", City: "台中市", Kind: kindDistrict, Latitude: 24.1596, Longitude: 120.6821},
+	{Name: "西屯區", City: "台中市", Kind: kindDistrict, Latitude: 24.1814, Longitude: 120.6269},
+	{Name: "南屯區", City: "台中市", Kind: kindDistrict, Latitude: 24.1384, Longitude: 120.6139},
+	{Name: "北屯區", City: "台中市", Kind: kindDistrict, Latitude: 24.1822, Longitude: 120.6860},
+	{Name: "大安區", City: "台中市", Kind: kindDistrict, Latitude: 24.3466, Longitude: 120.5863},
+
+	// 台南市
+	{Name: "中西區", City: "台南市", Kind: kindDistrict, Latitude: 22.9925, Longitude: 120.1987},
+	{Name: "東區", City: "台南市", Kind: kindDistrict, Latitude: 22.9798, Longitude: 120.2240},
+	{Name: "南區", City: "台南市", Kind: kindDistrict, Latitude: 22.9606, Longitude: 120.1887},
+	{Name: "北區", City: "台南市", Kind: kindDistrict, Latitude: 23.0075, Longitude: 120.2055},
+	{Name: "安平區", City: "台南市", Kind: kindDistrict, Latitude: 23.0007, Longitude: 120.1658},
+	{Name: "永康區", City: "台南市", Kind: kindDistrict, Latitude: 23.0262, Longitude: 120.2573},
+
+	// 高雄市
+	{Name: "新興區", City: "高雄市", Kind: kindDistrict, Latitude: 22.6310, Longitude: 120.3096},
+	{Name: "前金區", City: "高雄市", Kind: kindDistrict, Latitude: 22.6274, Longitude: 120.2945},
+	{Name: "苓雅區", City: "高雄市", Kind: kindDistrict, Latitude: 22.6216, Longitude: 120.3122},
+	{Name: "鹽埕區", City: "高雄市", Kind: kindDistrict, Latitude: 22.6247, Longitude: 120.2853},
+	{Name: "鼓山區", City: "高雄市", Kind: kindDistrict, Latitude: 22.6468, Longitude: 120.2734},
+	{Name: "左營區", City: "高雄市", Kind: kindDistrict, Latitude: 22.6900, Longitude: 120.2951},
+	{Name: "三民區", City: "高雄市", Kind: kindDistrict, Latitude: 22.6483, Longitude: 120.3155},
+	{Name: "前鎮區", City: "高雄市", Kind: kindDistrict, Latitude: 22.5954, Longitude: 120.3134},
+	{Name: "鳳山區", City: "高雄市", Kind: kindDistrict, Latitude: 22.6268, Longitude: 120.3575},
+
+	// 新竹市
+	{Name: "東區", City: "新竹市", Kind: kindDistrict, Latitude: 24.8016, Longitude: 120.9716},
+	{Name: "北區", City: "新竹市", Kind: kindDistrict, Latitude: 24.8163, Longitude: 120.9589},
+
+	// 嘉義市
+	{Name: "東區", City: "嘉義市", Kind: kindDistrict, Latitude: 23.4825, Longitude: 120.4593},
+	{Name: "西區", City: "嘉義市", Kind: kindDistrict, Latitude: 23.4769, Longitude: 120.4339},
+
+	// 南投縣
+	{Name: "信義鄉", City: "南投縣", Kind: kindDistrict, Latitude: 23.6999, Longitude: 120.8553},
+
+	// landmarks
+	{Name: "台北101", City: "台北市", Kind: kindLandmark, Aliases: []string{"101", "taipei 101"}, Latitude: 25.0339, Longitude: 121.5645},
+	{Name: "台北車站", City: "台北市", Kind: kindLandmark, Aliases: []string{"北車", "taipei main station"}, Latitude: 25.0478, Longitude: 121.5170},
+	{Name: "西門町", City: "台北市", Kind: kindLandmark, Aliases: []string{"西門"}, Latitude: 25.0422, Longitude: 121.5079},
+	{Name: "東區", City: "台北市", Kind: kindLandmark, Aliases: []string{"東區商圈"}, Latitude: 25.0416, Longitude: 121.5440},
+	{Name: "士林夜市", City: "台北市", Kind: kindLandmark, Latitude: 25.0880, Longitude: 121.5241},
+	{Name: "饒河夜市", City: "台北市", Kind: kindLandmark, Latitude: 25.0510, Longitude: 121.5776},
+	{Name: "寧夏夜市", City: "台北市", Kind: kindLandmark, Latitude: 25.0560, Longitude: 121.5154},
+	{Name: "師大夜市", City: "台北市", Kind: kindLandmark, Aliases: []string{"師大"}, Latitude: 25.0240, Longitude: 121.5290},
+	{Name: "公館", City: "台北市", Kind: kindLandmark, Latitude: 25.0146, Longitude: 121.5340},
+	{Name: "淡水老街", City: "新北市", Kind: kindLandmark, Latitude: 25.1700, Longitude: 121.4390},
+	{Name: "九份老街", City: "新北市", Kind: kindLandmark, Aliases: []string{"九份"}, Latitude: 25.1097, Longitude: 121.8451},
+	{Name: "板橋車站", City: "新北市", Kind: kindLandmark, Latitude: 25.0144, Longitude: 121.4637},
+	{Name: "桃園機場", City: "桃園市", Kind: kindLandmark, Aliases: []string{"taoyuan airport"}, Latitude: 25.0797, Longitude: 121.2342},
+	{Name: "逢甲夜市", City: "台中市", Kind: kindLandmark, Aliases: []string{"逢甲"}, Latitude: 24.1788, Longitude: 120.6467},
+	{Name: "台中車站", City: "台中市", Kind: kindLandmark, Latitude: 24.1374, Longitude: 120.6869},
+	{Name: "一中街", City: "台中市", Kind: kindLandmark, Aliases: []string{"一中"}, Latitude: 24.1497, Longitude: 120.6850},
+	{Name: "台南車站", City: "台南市", Kind: kindLandmark, Latitude: 22.9971, Longitude: 120.2126},
+	{Name: "花園夜市", City: "台南市", Kind: kindLandmark, Latitude: 23.0109, Longitude: 120.2004},
+	{Name: "赤崁樓", City: "台南市", Kind: kindLandmark, Latitude: 22.9975, Longitude: 120.2025},
+	{Name: "高雄車站", City: "高雄市", Kind: kindLandmark, Latitude: 22.6395, Longitude: 120.3025},
+	{Name: "駁二", City: "高雄市", Kind: kindLandmark, Aliases: []string{"駁二藝術特區"}, Latitude: 22.6200, Longitude: 120.2816},
+	{Name: "六合夜市", City: "高雄市", Kind: kindLandmark, Latitude: 22.6319, Longitude: 120.3017},
+	{Name: "新竹車站", City: "新竹市", Kind: kindLandmark, Latitude: 24.8016, Longitude: 120.9716},
+	{Name: "日月潭", City: "南投縣", Kind: kindLandmark, Latitude: 23.8576, Longitude: 120.9157},
+	{Name: "墾丁大街", City: "屏東縣", Kind: kindLandmark, Aliases: []string{"墾丁"}, Latitude: 21.9445, Longitude: 120.7965},
+	{Name: "羅東夜市", City: "宜蘭縣", Kind: kindLandmark, Latitude: 24.6757, Longitude: 121.7707},
+	{Name: "東大門夜市", City: "花蓮縣", Kind: kindLandmark, Latitude: 23.9744, Longitude: 121.6083},
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Geocoder turns what a user typed as a location into places. Several
+// results mean the text is ambiguous and the user should pick one; none means
+// the geocoder does not know it.
+type Geocoder interface {
+	Geocode(text string) ([]geoMatch, error)
+}
+
+// maxPlaceChoices is how many places the user is asked to pick from.
+const maxPlaceChoices = 9
+
+// geoMatch is one place a location text may mean.
+type geoMatch struct {
+	Label     string  `json:"label"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// placeKind orders matches: cities before districts before landmarks.
+type placeKind int
+
+// placeKind constants
+const (
+	kindCity placeKind = iota
+	kindDistrict
+	kindLandmark
+)
+
+// gazetteerEntry is a place in the bundled dataset. City is empty for cities
+// themselves.
+type gazetteerEntry struct {
+	Name      string
+	City      string
+	Kind      placeKind
+	Aliases   []string
+	Latitude  float64
+	Longitude float64
+}
+
+func (e gazetteerEntry) label() string {
+	switch e.Kind {
+	case kindDistrict:
+		return e.City + e.Name
+	case kindLandmark:
+		return e.Name + "（" + e.City + "）"
+	}
+	return e.Name
+}
+
+// gazetteer is an offline Geocoder over a fixed list of places.
+type gazetteer struct {
+	entries []gazetteerEntry
+	// keys maps every normalized way to write a place to its entries, and
+	// qualified the subset of those that name the city as well
+	keys      map[string][]int
+	qualified map[string][]int
+}
+
+func newGazetteer(entries []gazetteerEntry) *gazetteer {
+	g := &gazetteer{
+		entries:   entries,
+		keys:      make(map[string][]int),
+		qualified: make(map[string][]int),
+	}
+	for i, e := range entries {
+		names := append([]string{e.Name, shortPlaceName(e.Name)}, e.Aliases...)
+		for _, name := range names {
+			g.add(g.keys, name, i)
+			if e.City != "" {
+				for _, city := range []string{e.City, shortPlaceName(e.City)} {
+					g.add(g.keys, city+name, i)
+					g.add(g.qualified, city+name, i)
+				}
+			}
+		}
+	}
+	return g
+}
+
+func (g *gazetteer) add(keys map[string][]int, name string, i int) {
+	key := normalizePlace(name)
+	if key == "" {
+		return
+	}
+	for _, j := range keys[key] {
+		if j == i {
+			return
+		}
+	}
+	keys[key] = append(keys[key], i)
+}
+
+// Geocode matches the whole of text against the known names. Failing that,
+// it looks for a city and district written at the start of an address, as
+// in "台北市信義區松仁路100號".
+func (g *gazetteer) Geocode(text string) ([]geoMatch, error) {
+	q := normalizePlace(text)
+	if q == "" {
+		return nil, nil
+	}
+	found := g.keys[q]
+	if len(found) == 0 {
+		best := ""
+		for key := range g.qualified {
+			if strings.HasPrefix(q, key) && len(key) > len(best) {
+				best = key
+			}
+		}
+		if best != "" {
+			found = g.qualified[best]
+		}
+	}
+
+	found = append([]int(nil), found...)
+	sort.SliceStable(found, func(i, j int) bool {
+		return g.entries[found[i]].Kind < g.entries[found[j]].Kind
+	})
+	matches := make([]geoMatch, len(found))
+	for i, j := range found {
+		e := g.entries[j]
+		matches[i] = geoMatch{Label: e.label(), Latitude: e.Latitude, Longitude: e.Longitude}
+	}
+	return matches, nil
+}
+
+// normalizePlace folds the ways the same place gets typed: 臺 and 台, case,
+// spaces and punctuation.
+func normalizePlace(s string) string {
+	s = strings.Replace(s, "臺", "台", -1)
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '　':
+			return -1
+		case strings.ContainsRune(",.，。、!！?？", r):
+			return -1
+		}
+		return asciiLower(r)
+	}, s)
+	return s
+}
+
+// shortPlaceName drops the administrative suffix, so 信義區 is also 信義,
+// unless that would leave a single character.
+func shortPlaceName(name string) string {
+	for _, suffix := range []string{"區", "市", "縣", "鄉", "鎮"} {
+		if strings.HasSuffix(name, suffix) {
+			short := strings.TrimSuffix(name, suffix)
+			if utf8.RuneCountInString(short) > 1 {
+				return short
+			}
+		}
+	}
+	return name
+}
+
+// placeChoices asks the user which of matches they meant.
+func placeChoices(matches []geoMatch) string {
+	lines := []string{"你說的是哪裡呢？"}
+	for i, m := range matches {
+		lines = append(lines, strconv.Itoa(i+1)+". "+m.Label)
+	}
+	lines = append(lines, "", "請回覆號碼，或直接輸入更完整的地點")
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testGazetteer = []gazetteerEntry{
+	{Name: "台北市", Kind: kindCity, Aliases: []string{"taipei"}, Latitude: 25.04, Longitude: 121.56},
+	{Name: "基隆市", Kind: kindCity, Latitude: 25.13, Longitude: 121.74},
+	{Name: "中正區", City: "台北市", Kind: kindDistrict, Latitude: 25.03, Longitude: 121.52},
+	{Name: "中正區", City: "基隆市", Kind: kindDistrict, Latitude: 25.14, Longitude: 121.77},
+	{Name: "信義區", City: "台北市", Kind: kindDistrict, Latitude: 25.03, Longitude: 121.57},
+	{Name: "台北101", City: "台北市", Kind: kindLandmark, Aliases: []string{"101"}, Latitude: 25.03, Longitude: 121.56},
+	// a landmark that shares a district's short name sorts after it
+	{Name: "信義", City: "基隆市", Kind: kindLandmark, Latitude: 25.12, Longitude: 121.75},
+}
+
+func TestGeocode(t *testing.T) {
+	g := newGazetteer(testGazetteer)
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"台北市", []string{"台北市"}},
+		{"臺北市", []string{"台北市"}},
+		{"台北", []string{"台北市"}},
+		{" TaiPei ", []string{"台北市"}},
+		{"中正區", []string{"台北市中正區", "基隆市中正區"}},
+		{"中正", []string{"台北市中正區", "基隆市中正區"}},
+		{"基隆中正區", []string{"基隆市中正區"}},
+		{"台北市，中正", []string{"台北市中正區"}},
+		{"信義", []string{"台北市信義區", "信義（基隆市）"}},
+		{"101", []string{"台北101（台北市）"}},
+		// an address starts with the city and district
+		{"台北市信義區松仁路100號", []string{"台北市信義區"}},
+		{"臺北市中正區重慶南路一段", []string{"台北市中正區"}},
+		{"松仁路100號", nil},
+		{"", nil},
+		{"！？", nil},
+	}
+	for _, tt := range tests {
+		matches, err := g.Geocode(tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, m := range matches {
+			got = append(got, m.Label)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Geocode(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestShortPlaceName(t *testing.T) {
+	for name, want := range map[string]string{
+		"信義區":   "信義",
+		"台北市":   "台北",
+		"苗栗縣":   "苗栗",
+		"東區":    "東區",
+		"台北101": "台北101",
+	} {
+		if got := shortPlaceName(name); got != want {
+			t.Errorf("shortPlaceName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBundledGazetteer(t *testing.T) {
+	g := newGazetteer(taiwanPlaces)
+	for _, text := range []string{"台北", "Kaohsiung", "馬祖", "台北市信義區"} {
+		if matches, _ := g.Geocode(text); len(matches) != 1 {
+			t.Errorf("Geocode(%q) = %v", text, matches)
+		}
+	}
+	// every ambiguous name stays within what the user can pick from
+	for key, found := range g.keys {
+		if len(found) > maxPlaceChoices {
+			t.Errorf("%q matches %d places", key, len(found))
+		}
+	}
+}
+
+func TestPlaceChoices(t *testing.T) {
+	got := placeChoices([]geoMatch{{Label: "台北市中正區"}, {Label: "基隆市中正區"}})
+	if !strings.Contains(got, "1. 台北市中正區\n2. 基隆市中正區") {
+		t.Errorf("placeChoices = %q", got)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxHistory is how many recommendations are kept per user.
+const maxHistory = 200
+
+// historyEntry is one business recommended to a user, and what they said
+// about it: 1 for liked, -1 for rejected, 0 for no feedback yet.
+type historyEntry struct {
+	Business   string    `json:"business"`
+	Categories []string  `json:"categories,omitempty"`
+	Turn       int       `json:"turn"`
+	At         time.Time `json:"at"`
+	Feedback   int       `json:"feedback,omitempty"`
+}
+
+type historyRecord struct {
+	User     string        `json:"user"`
+	Entry    *historyEntry `json:"entry,omitempty"`
+	Business string        `json:"business,omitempty"`
+	Feedback int           `json:"feedback,omitempty"`
+	Forget   bool          `json:"forget,omitempty"`
+}
+
+// historyStore remembers what each user was recommended and how they felt
+// about it.
+type historyStore struct {
+	mu      sync.Mutex
+	users   map[string][]historyEntry
+	journal *journal
+}
+
+func newHistoryStore() *historyStore {
+	return &historyStore{users: make(map[string][]historyEntry)}
+}
+
+// openHistoryStore returns a historyStore journaled to path.
+func openHistoryStore(path string) (*historyStore, error) {
+	h := newHistoryStore()
+	j, err := openJournal(path, func(line []byte) error {
+		var r historyRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		h.apply(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := j.Compact(h.live()); err != nil {
+		j.Close()
+		return nil, err
+	}
+	h.journal = j
+	return h, nil
+}
+
+// apply changes the in-memory state for r. Callers hold h.mu.
+func (h *historyStore) apply(r historyRecord) {
+	switch {
+	case r.Forget:
+		delete(h.users, r.User)
+	case r.Entry != nil:
+		entries := append(h.users[r.User], *r.Entry)
+		if len(entries) > maxHistory {
+			entries = entries[len(entries)-maxHistory:]
+		}
+		h.users[r.User] = entries
+	default:
+		entries := h.users[r.User]
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].Business == r.Business {
+				entries[i].Feedback = r.Feedback
+				break
+			}
+		}
+	}
+}
+
+// live returns every entry as a record. Callers hold h.mu, or own h
+// exclusively.
+func (h *historyStore) live() []interface{} {
+	var live []interface{}
+	for user, entries := range h.users {
+		for i := range entries {
+			live = append(live, historyRecord{User: user, Entry: &entries[i]})
+		}
+	}
+	return live
+}
+
+// record applies and journals r. Callers hold h.mu.
+func (h *historyStore) record(r historyRecord) {
+	h.apply(r)
+	if h.journal == nil {
+		return
+	}
+	if err := h.journal.Append(r); err != nil {
+		log.Println(err)
+	}
+	if err := h.journal.CompactIfDue(h.live); err != nil {
+		log.Println(err)
+	}
+}
+
+// Recommended records that businesses were shown to user together.
+func (h *historyStore) Recommended(user string, businesses []Place) {
+	if len(businesses) == 0 {
+		return
+	}
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	turn := 1
+	if entries := h.users[user]; len(entries) > 0 {
+		turn = entries[len(entries)-1].Turn + 1
+	}
+	for _, b := range businesses {
+		h.record(historyRecord{User: user, Entry: &historyEntry{
+			Business:   b.ID,
+			Categories: b.CategoryAliases(),
+			Turn:       turn,
+			At:         now,
+		}})
+	}
+}
+
+// LastTurn returns the IDs of the businesses most recently shown to user,
+// in the order they were shown.
+func (h *historyStore) LastTurn(user string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := h.users[user]
+	if len(entries) == 0 {
+		return nil
+	}
+	turn := entries[len(entries)-1].Turn
+	var ids []string
+	for _, e := range entries {
+		if e.Turn == turn {
+			ids = append(ids, e.Business)
+		}
+	}
+	return ids
+}
+
+// Feedback records that user liked (1) or rejected (-1) business.
+func (h *historyStore) Feedback(user, business string, vote int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.record(historyRecord{User: user, Business: business, Feedback: vote})
+}
+
+// Forget drops everything recommended to user.
+func (h *historyStore) Forget(user string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.users[user]; ok {
+		h.record(historyRecord{User: user, Forget: true})
+	}
+}
+
+// Entries returns a copy of user's history, oldest first.
+func (h *historyStore) Entries(user string) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]historyEntry(nil), h.users[user]...)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryTurns(t *testing.T) {
+	h := newHistoryStore()
+	if ids := h.LastTurn("alice"); ids != nil {
+		t.Errorf("LastTurn without history = %v", ids)
+	}
+	h.Recommended("alice", []Place{{ID: "a"}, {ID: "b"}})
+	h.Recommended("alice", []Place{{ID: "c"}, {ID: "a"}})
+	h.Recommended("alice", nil)
+	if ids := h.LastTurn("alice"); !reflect.DeepEqual(ids, []string{"c", "a"}) {
+		t.Errorf("LastTurn = %v", ids)
+	}
+
+	// feedback goes to the latest showing of the business
+	h.Feedback("alice", "a", -1)
+	entries := h.Entries("alice")
+	if len(entries) != 4 || entries[0].Feedback != 0 || entries[3].Feedback != -1 || entries[3].Turn != 2 {
+		t.Errorf("entries = %+v", entries)
+	}
+
+	h.Forget("alice")
+	if len(h.Entries("alice")) != 0 || h.LastTurn("alice") != nil {
+		t.Error("history kept after Forget")
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	h := newHistoryStore()
+	for i := 0; i < maxHistory+5; i++ {
+		h.Recommended("alice", []Place{{ID: "a"}})
+	}
+	entries := h.Entries("alice")
+	if len(entries) != maxHistory || entries[len(entries)-1].Turn != maxHistory+5 {
+		t.Errorf("%d entries, last turn %d", len(entries), entries[len(entries)-1].Turn)
+	}
+}
+
+func TestHistoryJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.log")
+
+	h, err := openHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ramen := Place{ID: "a", Categories: []PlaceCategory{{Alias: "ramen"}}}
+	h.Recommended("alice", []Place{ramen, {ID: "b"}})
+	h.Feedback("alice", "a", 1)
+	h.Recommended("bob", []Place{ramen})
+	h.Forget("bob")
+	h.journal.Close()
+
+	h, err = openHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.journal.Close()
+	entries := h.Entries("alice")
+	if len(entries) != 2 || entries[0].Feedback != 1 || !reflect.DeepEqual(entries[0].Categories, []string{"ramen"}) {
+		t.Errorf("alice after a restart: %+v", entries)
+	}
+	if len(h.Entries("bob")) != 0 {
+		t.Error("bob's history came back after Forget")
+	}
+}
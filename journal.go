@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// journalCompactMin is the fewest records appended since the last
+// compaction that make compacting again worthwhile.
+const journalCompactMin = 1000
+
+// journal is an append-only file of JSON records, one per line.
+// Stores replay it on startup and compact it once the live state is known,
+// then again whenever CompactIfDue finds it has grown.
+type journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	// live is how many records the last compaction kept, and appended how
+	// many were written since
+	live     int
+	appended int
+}
+
+// openJournal replays every record in path through fn and leaves the file
+// open for appending. Lines that fail to decode are skipped. A last line cut
+// short by a crash is cut off the file, or ended if it still decoded, so the
+// next record starts on a line of its own.
+func openJournal(path string, fn func(line []byte) error) (*journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	var lines int
+	var torn, tornOK bool
+	var end int64
+	if r, err := os.Open(path); err == nil {
+		info, err := r.Stat()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		var last int
+		for scanner.Scan() {
+			last = len(scanner.Bytes())
+			tornOK = fn(scanner.Bytes()) == nil
+			end += int64(last) + 1
+			lines++
+		}
+		r.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		// the scanner counted a newline the file does not have
+		if end > info.Size() {
+			torn = true
+			end -= int64(last) + 1
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if torn && !tornOK {
+		if err := os.Truncate(path, end); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if torn && tornOK {
+		if _, err := f.Write([]byte{'\n'}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &journal{path: path, f: f, appended: lines}, nil
+}
+
+// Append writes v as a single record.
+func (j *journal) Append(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.f.Write(append(b, '\n'))
+	j.appended++
+	return err
+}
+
+// CompactIfDue compacts the journal to live() once more records have been
+// appended since the last compaction than it kept. Callers hold the lock
+// they append under, so no record lands between live() and the new file.
+func (j *journal) CompactIfDue(live func() []interface{}) error {
+	j.mu.Lock()
+	due := j.appended >= journalCompactMin && j.appended > j.live
+	j.mu.Unlock()
+	if !due {
+		return nil
+	}
+	return j.Compact(live())
+}
+
+// Compact replaces the journal with the given records.
+func (j *journal) Compact(records []interface{}) error {
+	tmp := j.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	j.live, j.appended = len(records), 0
+	j.f.Close()
+	j.f, err = os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// Close closes the underlying file.
+func (j *journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
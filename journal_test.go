@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testRecord struct {
+	Key   string `json:"key"`
+	Value int    `json:"value"`
+}
+
+// replay opens the journal at path and returns the records it held.
+func replay(t *testing.T, path string) (*journal, []testRecord) {
+	var records []testRecord
+	j, err := openJournal(path, func(line []byte) error {
+		var r testRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j, records
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data", "test.log")
+
+	j, records := replay(t, path)
+	if len(records) != 0 {
+		t.Fatalf("new journal replayed %v", records)
+	}
+	for i, key := range []string{"a", "b", "a"} {
+		if err := j.Append(testRecord{Key: key, Value: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	// a torn last write must not stop the rest from loading
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{\"key\": \"c\", \"val\n")
+	f.Close()
+
+	j, records = replay(t, path)
+	want := []testRecord{{"a", 0}, {"b", 1}, {"a", 2}}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("replayed %v, want %v", records, want)
+	}
+
+	live := []interface{}{testRecord{"b", 1}, testRecord{"a", 2}}
+	if err := j.Compact(live); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Append(testRecord{Key: "d", Value: 3}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j, records = replay(t, path)
+	defer j.Close()
+	want = []testRecord{{"b", 1}, {"a", 2}, {"d", 3}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("after compaction replayed %v, want %v", records, want)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("compaction left its temporary file behind")
+	}
+}
+
+func TestJournalAppendAfterTornWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tail := range []struct {
+		name, text string
+		want       []testRecord
+	}{
+		{"cut", `{"key": "b", "val`, []testRecord{{"a", 0}, {"c", 2}}},
+		{"unterminated", `{"key": "b", "value": 1}`, []testRecord{{"a", 0}, {"b", 1}, {"c", 2}}},
+	} {
+		path := filepath.Join(dir, tail.name+".log")
+		if err := ioutil.WriteFile(path, []byte("{\"key\": \"a\", \"value\": 0}\n"+tail.text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		j, _ := replay(t, path)
+		if err := j.Append(testRecord{Key: "c", Value: 2}); err != nil {
+			t.Fatal(err)
+		}
+		j.Close()
+
+		j, records := replay(t, path)
+		j.Close()
+		if !reflect.DeepEqual(records, tail.want) {
+			t.Errorf("%s tail: replayed %v, want %v", tail.name, records, tail.want)
+		}
+	}
+}
+
+func TestJournalCompactIfDue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.log")
+
+	j, _ := replay(t, path)
+	live := map[string]int{}
+	snapshot := func() []interface{} {
+		var records []interface{}
+		for key, value := range live {
+			records = append(records, testRecord{key, value})
+		}
+		return records
+	}
+	compactions := 0
+	for i := 0; i < 3*journalCompactMin; i++ {
+		r := testRecord{Key: string('a' + rune(i%3)), Value: i}
+		live[r.Key] = r.Value
+		if err := j.Append(r); err != nil {
+			t.Fatal(err)
+		}
+		before := j.appended
+		if err := j.CompactIfDue(snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if j.appended < before {
+			compactions++
+		}
+	}
+	j.Close()
+	if compactions != 3 {
+		t.Errorf("compacted %d times over %d appends", compactions, 3*journalCompactMin)
+	}
+
+	j, records := replay(t, path)
+	defer j.Close()
+	if len(records) != 3 {
+		t.Errorf("replayed %d records after compacting, want 3", len(records))
+	}
+}
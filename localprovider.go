@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// localRadius is how far a local search looks when the query sets no
+	// radius, in meters.
+	localRadius = 5000
+	// gridCell is the side of a spatial index cell in degrees, about 1.1 km.
+	gridCell = 0.01
+	// metersPerDegree is the length of a degree of latitude.
+	metersPerDegree = 111320
+)
+
+// localProvider is a PlaceProvider over restaurants loaded from files, so the
+// bot runs without Yelp and can carry spots Yelp does not list well.
+type localProvider struct {
+	places []Place
+	byID   map[string]int
+	// cells buckets places by gridCell, and words maps every token of their
+	// names and categories to them
+	cells map[gridKey][]int
+	words map[string][]int
+	// geocoder places a location typed without coordinates
+	geocoder Geocoder
+}
+
+type gridKey struct {
+	Lat, Lng int
+}
+
+func gridKeyOf(latitude, longitude float64) gridKey {
+	return gridKey{int(math.Floor(latitude / gridCell)), int(math.Floor(longitude / gridCell))}
+}
+
+func newLocalProvider(places []Place, geocoder Geocoder) (*localProvider, error) {
+	l := &localProvider{
+		places:   places,
+		byID:     make(map[string]int),
+		cells:    make(map[gridKey][]int),
+		words:    make(map[string][]int),
+		geocoder: geocoder,
+	}
+	for i, p := range places {
+		if _, ok := l.byID[p.ID]; ok {
+			return nil, fmt.Errorf("duplicate place id %q", p.ID)
+		}
+		l.byID[p.ID] = i
+		k := gridKeyOf(p.Latitude, p.Longitude)
+		l.cells[k] = append(l.cells[k], i)
+
+		seen := make(map[string]bool)
+		text := []string{p.Name}
+		for _, c := range p.Categories {
+			text = append(text, c.Name, c.Alias)
+		}
+		for _, t := range text {
+			for _, w := range textTokens(t, true) {
+				if !seen[w] {
+					seen[w] = true
+					l.words[w] = append(l.words[w], i)
+				}
+			}
+		}
+	}
+	return l, nil
+}
+
+// openLocalProvider loads every file in paths, each either CSV or JSON by its
+// extension.
+func openLocalProvider(paths []string, geocoder Geocoder) (*localProvider, error) {
+	var places []Place
+	for _, path := range paths {
+		loaded, err := loadPlaces(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		places = append(places, loaded...)
+	}
+	return newLocalProvider(places, geocoder)
+}
+
+func loadPlaces(path string) ([]Place, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var places []Place
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.NewDecoder(f).Decode(&places)
+	case ".csv":
+		places, err = readPlacesCSV(f)
+	default:
+		return nil, errors.New("want a .csv or .json file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range places {
+		if places[i].Name == "" {
+			return nil, fmt.Errorf("place %d has no name", i+1)
+		}
+		if places[i].ID == "" {
+			places[i].ID = localPlaceID(places[i])
+		}
+	}
+	return places, nil
+}
+
+// readPlacesCSV reads a CSV file whose header names the columns after Place's
+// JSON fields. categories holds "name:alias" pairs, or bare aliases, split by
+// ";"; price is either a number or dollar signs.
+func readPlacesCSV(r io.Reader) ([]Place, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"name", "latitude", "longitude"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("no %s column", required)
+		}
+	}
+
+	var places []Place
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return places, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		p := Place{
+			ID:       field("id"),
+			Name:     field("name"),
+			URL:      field("url"),
+			PhotoURL: field("photo_url"),
+			Phone:    field("phone"),
+		}
+		if p.Latitude, err = strconv.ParseFloat(field("latitude"), 64); err != nil {
+			return nil, fmt.Errorf("line %d: bad latitude", line)
+		}
+		if p.Longitude, err = strconv.ParseFloat(field("longitude"), 64); err != nil {
+			return nil, fmt.Errorf("line %d: bad longitude", line)
+		}
+		if s := field("rating"); s != "" {
+			if p.Rating, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("line %d: bad rating", line)
+			}
+		}
+		if s := field("review_count"); s != "" {
+			if p.ReviewCount, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("line %d: bad review_count", line)
+			}
+		}
+		if s := field("price"); s != "" {
+			if strings.Trim(s, "$") == "" {
+				p.Price = len(s)
+			} else if p.Price, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("line %d: bad price", line)
+			}
+		}
+		if s := field("address"); s != "" {
+			p.Address = []string{s}
+		}
+		for _, c := range strings.Split(field("categories"), ";") {
+			c = strings.TrimSpace(c)
+			if c == "" {
+				continue
+			}
+			pc := PlaceCategory{Name: c, Alias: c}
+			if i := strings.LastIndex(c, ":"); i >= 0 {
+				pc.Name, pc.Alias = strings.TrimSpace(c[:i]), strings.TrimSpace(c[i+1:])
+			}
+			p.Categories = append(p.Categories, pc)
+		}
+		places = append(places, p)
+	}
+}
+
+// localPlaceID names a place given without an id by what it is and where, so
+// favorites and history still find it after the file is edited or reordered.
+func localPlaceID(p Place) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%.5f|%.5f", p.Name, p.Latitude, p.Longitude)
+	return fmt.Sprintf("local-%x", h.Sum64())
+}
+
+func (l *localProvider) SearchByCoordinate(q PlaceQuery, latitude, longitude float64) (PlaceResults, error) {
+	radius := float64(localRadius)
+	if q.Radius.Valid && q.Radius.Float64 > 0 {
+		radius = q.Radius.Float64
+	}
+	var matches []Place
+	for _, i := range l.nearby(latitude, longitude, radius) {
+		p := l.places[i]
+		p.Distance = distanceMeters(latitude, longitude, p.Latitude, p.Longitude)
+		matches = append(matches, p)
+	}
+	return l.results(q, matches), nil
+}
+
+// SearchByText searches around location. An address that belongs to exactly
+// one known place is the most precise point there is; after that come hint
+// and the geocoder. Failing all three, it keeps the places whose address
+// holds location, or answers errUnknownLocation if there are none.
+func (l *localProvider) SearchByText(q PlaceQuery, location string, hint *LatLng) (PlaceResults, error) {
+	want := normalizePlace(location)
+	var matches []Place
+	for _, p := range l.places {
+		if want != "" && strings.Contains(normalizePlace(strings.Join(p.Address, "")), want) {
+			matches = append(matches, p)
+		}
+	}
+	if len(matches) == 1 {
+		return l.SearchByCoordinate(q, matches[0].Latitude, matches[0].Longitude)
+	}
+	if hint != nil {
+		return l.SearchByCoordinate(q, hint.Latitude, hint.Longitude)
+	}
+	if l.geocoder != nil {
+		found, err := l.geocoder.Geocode(location)
+		if err != nil {
+			return PlaceResults{}, err
+		}
+		if len(found) > 0 {
+			return l.SearchByCoordinate(q, found[0].Latitude, found[0].Longitude)
+		}
+	}
+	if len(matches) == 0 {
+		return PlaceResults{}, errUnknownLocation
+	}
+	return l.results(q, matches), nil
+}
+
+func (l *localProvider) Details(id string) (Place, error) {
+	i, ok := l.byID[id]
+	if !ok {
+		return Place{}, errPlaceNotFound
+	}
+	return l.places[i], nil
+}
+
+// results filters candidates by q, sorts them and cuts out the page q asks
+// for.
+func (l *localProvider) results(q PlaceQuery, candidates []Place) PlaceResults {
+	var matched map[int]bool
+	if tokens := textTokens(q.Term, false); len(tokens) > 0 {
+		matched = l.matchTerm(tokens)
+	}
+	var places []Place
+	for _, p := range candidates {
+		if p.IsClosed {
+			continue
+		}
+		if matched != nil && !matched[l.byID[p.ID]] {
+			continue
+		}
+		if len(q.Categories) > 0 && !sharesCategory(p, q.Categories) {
+			continue
+		}
+		if q.Deals.Valid && q.Deals.Bool && len(p.Deals) == 0 {
+			continue
+		}
+		if q.MaxPrice > 0 && p.Price > q.MaxPrice {
+			continue
+		}
+		places = append(places, p)
+	}
+
+	sortBy := int64(sortBestMatched)
+	if q.Sort.Valid {
+		sortBy = q.Sort.Int64
+	}
+	sort.SliceStable(places, func(i, j int) bool {
+		a, b := places[i], places[j]
+		if sortBy == sortDistance && a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+		if a.ReviewCount != b.ReviewCount {
+			return a.ReviewCount > b.ReviewCount
+		}
+		return a.Distance < b.Distance
+	})
+
+	total := len(places)
+	limit := q.Limit
+	if limit <= 0 {
+		limit = pageSize
+	}
+	if q.Offset >= len(places) {
+		places = nil
+	} else {
+		places = places[q.Offset:]
+	}
+	if len(places) > limit {
+		places = places[:limit]
+	}
+	return PlaceResults{Total: total, Places: places}
+}
+
+// matchTerm returns the places whose text holds every one of tokens.
+func (l *localProvider) matchTerm(tokens []string) map[int]bool {
+	matched := make(map[int]bool)
+	for _, i := range l.words[tokens[0]] {
+		matched[i] = true
+	}
+	for _, w := range tokens[1:] {
+		next := make(map[int]bool)
+		for _, i := range l.words[w] {
+			if matched[i] {
+				next[i] = true
+			}
+		}
+		matched = next
+	}
+	return matched
+}
+
+// nearby returns the places within radius meters of a point.
+func (l *localProvider) nearby(latitude, longitude, radius float64) []int {
+	dLat := radius / metersPerDegree
+	dLng := dLat
+	if c := math.Cos(latitude * math.Pi / 180); c > 0.01 {
+		dLng = dLat / c
+	}
+	var found []int
+	for _, i := range l.within(latitude-dLat, longitude-dLng, latitude+dLat, longitude+dLng) {
+		p := l.places[i]
+		if distanceMeters(latitude, longitude, p.Latitude, p.Longitude) <= radius {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// within returns the places inside a bounding box.
+func (l *localProvider) within(south, west, north, east float64) []int {
+	lo, hi := gridKeyOf(south, west), gridKeyOf(north, east)
+	var found []int
+	for lat := lo.Lat; lat <= hi.Lat; lat++ {
+		for lng := lo.Lng; lng <= hi.Lng; lng++ {
+			for _, i := range l.cells[gridKey{lat, lng}] {
+				p := l.places[i]
+				if p.Latitude >= south && p.Latitude <= north && p.Longitude >= west && p.Longitude <= east {
+					found = append(found, i)
+				}
+			}
+		}
+	}
+	return found
+}
+
+func sharesCategory(p Place, aliases []string) bool {
+	for _, a := range p.CategoryAliases() {
+		if containsString(aliases, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// textTokens splits s into lower-cased words of letters and digits, and runs
+// of Chinese characters into overlapping pairs, so "台北牛肉麵" is found by
+// "牛肉麵". A lone Chinese character is its own token; when indexing, every
+// character is, so that "麵" alone finds it too.
+func textTokens(s string, index bool) []string {
+	s = strings.Replace(s, "臺", "台", -1)
+	var tokens []string
+	var word []rune
+	var han []rune
+	flush := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+		if len(han) == 1 || index {
+			for _, r := range han {
+				tokens = append(tokens, string(r))
+			}
+		}
+		for i := 0; i+1 < len(han); i++ {
+			tokens = append(tokens, string(han[i:i+2]))
+		}
+		han = han[:0]
+	}
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			if len(word) > 0 {
+				flush()
+			}
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if len(han) > 0 {
+				flush()
+			}
+			word = append(word, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// distanceMeters is the great-circle distance between two points.
+func distanceMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadius = 6371000
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
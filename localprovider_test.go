@@ -0,0 +1,272 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/guregu/null"
+)
+
+func TestLoadPlaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "places")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const header = "id,name,latitude,longitude,categories,rating,review_count,price,address\n"
+	tests := []struct {
+		file, content string
+		want          []Place
+		err           string
+	}{
+		{
+			file: "good.csv",
+			content: header +
+				"r1, 一蘭拉麵, 25.04, 121.56, 拉麵:ramen;japanese, 4.5, 120, $$, 台北市信義區松仁路1號\n" +
+				",Pho House,25.05,121.57,,,,3,\n",
+			want: []Place{
+				{ID: "r1", Name: "一蘭拉麵", Latitude: 25.04, Longitude: 121.56, Rating: 4.5, ReviewCount: 120, Price: 2,
+					Address:    []string{"台北市信義區松仁路1號"},
+					Categories: []PlaceCategory{{Name: "拉麵", Alias: "ramen"}, {Name: "japanese", Alias: "japanese"}}},
+				{ID: localPlaceID(Place{Name: "Pho House", Latitude: 25.05, Longitude: 121.57}), Name: "Pho House", Latitude: 25.05, Longitude: 121.57, Price: 3},
+			},
+		},
+		{file: "latitude.csv", content: header + "r1,一蘭,25.04,121.56,,,,,\nr2,二蘭,north,121.56,,,,,\n", err: "line 3: bad latitude"},
+		{file: "price.csv", content: header + "r1,一蘭,25.04,121.56,,,,cheap,\n", err: "line 2: bad price"},
+		{file: "columns.csv", content: "id,name,latitude\nr1,一蘭,25.04\n", err: "no longitude column"},
+		{file: "unnamed.csv", content: header + "r1,,25.04,121.56,,,,,\n", err: "place 1 has no name"},
+		{
+			file:    "good.json",
+			content: `[{"id": "r1", "name": "一蘭", "latitude": 25.04, "longitude": 121.56, "price": 2, "deals": [{"title": "九折"}]}]`,
+			want:    []Place{{ID: "r1", Name: "一蘭", Latitude: 25.04, Longitude: 121.56, Price: 2, Deals: []PlaceDeal{{Title: "九折"}}}},
+		},
+		{file: "broken.json", content: `[{"id": "r1", "name": `, err: "unexpected EOF"},
+		{file: "places.txt", content: "一蘭", err: "want a .csv or .json file"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadPlaces(path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", tt.file, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: loaded\n%+v\nwant\n%+v", tt.file, got, tt.want)
+		}
+	}
+
+	if _, err := openLocalProvider([]string{filepath.Join("data", "places.example.csv")}, nil); err != nil {
+		t.Errorf("example data: %v", err)
+	}
+}
+
+func sortedIDs(places []Place) []string {
+	ids := placeIDs(places)
+	sort.Strings(ids)
+	return ids
+}
+
+func TestLocalRadiusAcrossCells(t *testing.T) {
+	// the point sits on a cell corner, with places either side of it
+	const lat, lng = 25.01, 121.51
+	step := 1 / float64(metersPerDegree)
+	l, err := newLocalProvider([]Place{
+		{ID: "n90", Name: "n", Latitude: lat + 90*step, Longitude: lng},
+		{ID: "s90", Name: "s", Latitude: lat - 90*step, Longitude: lng},
+		{ID: "w90", Name: "w", Latitude: lat, Longitude: lng - 90*step/0.906},
+		{ID: "e110", Name: "e", Latitude: lat, Longitude: lng + 110*step/0.906},
+		{ID: "s1500", Name: "far", Latitude: lat - 1500*step, Longitude: lng},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		radius float64
+		want   []string
+	}{
+		{100, []string{"n90", "s90", "w90"}},
+		{120, []string{"e110", "n90", "s90", "w90"}},
+		{1400, []string{"e110", "n90", "s90", "w90"}},
+		{1600, []string{"e110", "n90", "s1500", "s90", "w90"}},
+	}
+	for _, tt := range tests {
+		results, _ := l.SearchByCoordinate(PlaceQuery{Radius: null.FloatFrom(tt.radius)}, lat, lng)
+		if got := sortedIDs(results.Places); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("radius %v: found %v, want %v", tt.radius, got, tt.want)
+		}
+		for _, p := range results.Places {
+			if p.Distance > tt.radius {
+				t.Errorf("radius %v: %s is %v away", tt.radius, p.ID, p.Distance)
+			}
+		}
+	}
+}
+
+func TestLocalWithin(t *testing.T) {
+	l, _ := newLocalProvider([]Place{
+		{ID: "inside", Name: "a", Latitude: 25.035, Longitude: 121.555},
+		{ID: "edge", Name: "b", Latitude: 25.04, Longitude: 121.56},
+		{ID: "north", Name: "c", Latitude: 25.0401, Longitude: 121.555},
+		{ID: "west", Name: "d", Latitude: 25.035, Longitude: 121.5299},
+	}, nil)
+	var got []string
+	for _, i := range l.within(25.02, 121.53, 25.04, 121.56) {
+		got = append(got, l.places[i].ID)
+	}
+	sort.Strings(got)
+	if want := []string{"edge", "inside"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("within = %v, want %v", got, want)
+	}
+}
+
+type fakeGeocoder map[string]geoMatch
+
+func (g fakeGeocoder) Geocode(text string) ([]geoMatch, error) {
+	if m, ok := g[text]; ok {
+		return []geoMatch{m}, nil
+	}
+	return nil, nil
+}
+
+func TestLocalSearchByText(t *testing.T) {
+	l, _ := newLocalProvider([]Place{
+		{ID: "xinyi", Name: "a", Latitude: 25.0336, Longitude: 121.5646, Address: []string{"台北市信義區松仁路100號"}},
+		{ID: "xinyi2", Name: "b", Latitude: 25.0378, Longitude: 121.5680, Address: []string{"台北市信義區松高路12號"}},
+		{ID: "daan", Name: "c", Latitude: 25.0324, Longitude: 121.5297, Address: []string{"臺北市大安區永康街10號"}},
+	}, fakeGeocoder{"永康街": {Label: "永康街", Latitude: 25.0324, Longitude: 121.5297}})
+	near := PlaceQuery{Radius: null.FloatFrom(100)}
+
+	tests := []struct {
+		location string
+		hint     *LatLng
+		want     []string
+		err      error
+	}{
+		// one address match wins over the hint
+		{"松仁路100號", &LatLng{Latitude: 25.0324, Longitude: 121.5297}, []string{"xinyi"}, nil},
+		{"somewhere", &LatLng{Latitude: 25.0324, Longitude: 121.5297}, []string{"daan"}, nil},
+		{"永康街", nil, []string{"daan"}, nil},
+		{"台北市信義區", nil, []string{"xinyi", "xinyi2"}, nil},
+		{"台北市", nil, []string{"daan", "xinyi", "xinyi2"}, nil},
+		{"高雄", nil, nil, errUnknownLocation},
+	}
+	for _, tt := range tests {
+		results, err := l.SearchByText(near, tt.location, tt.hint)
+		if err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.location, err, tt.err)
+			continue
+		}
+		if got := sortedIDs(results.Places); len(got)+len(tt.want) > 0 && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: found %v, want %v", tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestTextTokens(t *testing.T) {
+	tests := []struct {
+		text  string
+		index bool
+		want  []string
+	}{
+		{"牛肉麵", false, []string{"牛肉", "肉麵"}},
+		{"麵", false, []string{"麵"}},
+		{"臺北 Beef-Noodle", false, []string{"台北", "beef", "noodle"}},
+		{"牛肉麵", true, []string{"牛", "肉", "麵", "牛肉", "肉麵"}},
+		{"Pho河粉2號", false, []string{"pho", "河粉", "2", "號"}},
+	}
+	for _, tt := range tests {
+		if got := textTokens(tt.text, tt.index); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("textTokens(%q, %v) = %q, want %q", tt.text, tt.index, got, tt.want)
+		}
+	}
+}
+
+func localTestPlaces() []Place {
+	return []Place{
+		{ID: "beef", Name: "台北牛肉麵館", Latitude: 25.0330, Longitude: 121.5650, Rating: 4.5, ReviewCount: 300, Price: 1},
+		{ID: "ramen", Name: "一蘭", Latitude: 25.0340, Longitude: 121.5650, Rating: 4.0, Price: 2,
+			Categories: []PlaceCategory{{Name: "拉麵", Alias: "ramen"}}, Deals: []PlaceDeal{{Title: "九折"}}},
+		{ID: "steak", Name: "Steak House", Latitude: 25.0350, Longitude: 121.5650, Rating: 4.5, ReviewCount: 20, Price: 4},
+		{ID: "gone", Name: "老牛肉麵", Latitude: 25.0336, Longitude: 121.5650, Rating: 5, IsClosed: true},
+		{ID: "noodle", Name: "Noodle Bar", Latitude: 25.0360, Longitude: 121.5650, Rating: 3.5, Price: 2,
+			Categories: []PlaceCategory{{Name: "Noodles", Alias: "noodles"}}, Deals: []PlaceDeal{{Title: "買一送一"}}},
+	}
+}
+
+func TestLocalFilters(t *testing.T) {
+	l, _ := newLocalProvider(localTestPlaces(), nil)
+	tests := []struct {
+		name string
+		q    PlaceQuery
+		want []string
+	}{
+		{"everything open, best first", PlaceQuery{}, []string{"beef", "steak", "ramen", "noodle"}},
+		{"nearest first", PlaceQuery{Sort: null.IntFrom(sortDistance)}, []string{"beef", "ramen", "steak", "noodle"}},
+		{"han bigrams", PlaceQuery{Term: "牛肉麵"}, []string{"beef"}},
+		{"one han", PlaceQuery{Term: "麵"}, []string{"beef", "ramen"}},
+		{"category name", PlaceQuery{Term: "拉麵"}, []string{"ramen"}},
+		{"words", PlaceQuery{Term: "noodle BAR"}, []string{"noodle"}},
+		{"no match", PlaceQuery{Term: "pizza"}, nil},
+		{"categories", PlaceQuery{Categories: []string{"ramen", "noodles"}}, []string{"ramen", "noodle"}},
+		{"deals", PlaceQuery{Deals: null.BoolFrom(true)}, []string{"ramen", "noodle"}},
+		{"deals off", PlaceQuery{Deals: null.BoolFrom(false)}, []string{"beef", "steak", "ramen", "noodle"}},
+		{"max price", PlaceQuery{MaxPrice: 2}, []string{"beef", "ramen", "noodle"}},
+		{"cheapest", PlaceQuery{MaxPrice: 1}, []string{"beef"}},
+		{"max price and deals", PlaceQuery{MaxPrice: 2, Deals: null.BoolFrom(true)}, []string{"ramen", "noodle"}},
+	}
+	for _, tt := range tests {
+		results, _ := l.SearchByCoordinate(tt.q, 25.0330, 121.5650)
+		if got := placeIDs(results.Places); !reflect.DeepEqual(got, tt.want) && len(got)+len(tt.want) > 0 {
+			t.Errorf("%s: found %v, want %v", tt.name, got, tt.want)
+		}
+		if results.Total != len(tt.want) {
+			t.Errorf("%s: total %d, want %d", tt.name, results.Total, len(tt.want))
+		}
+	}
+}
+
+func TestLocalPaging(t *testing.T) {
+	l, _ := newLocalProvider(localTestPlaces(), nil)
+	tests := []struct {
+		limit, offset int
+		want          []string
+	}{
+		{2, 0, []string{"beef", "steak"}},
+		{2, 2, []string{"ramen", "noodle"}},
+		{2, 3, []string{"noodle"}},
+		{2, 4, nil},
+		{0, 1, []string{"steak", "ramen", "noodle"}},
+	}
+	for _, tt := range tests {
+		results, _ := l.SearchByCoordinate(PlaceQuery{Limit: tt.limit, Offset: tt.offset}, 25.0330, 121.5650)
+		if got := placeIDs(results.Places); !reflect.DeepEqual(got, tt.want) && len(got)+len(tt.want) > 0 {
+			t.Errorf("limit %d offset %d: found %v, want %v", tt.limit, tt.offset, got, tt.want)
+		}
+		if results.Total != 4 {
+			t.Errorf("limit %d offset %d: total %d, want 4", tt.limit, tt.offset, results.Total)
+		}
+	}
+
+	if _, err := l.Details("gone"); err != nil {
+		t.Errorf("a closed place still has details: %v", err)
+	}
+	if _, err := l.Details("nowhere"); err != errPlaceNotFound {
+		t.Errorf("unknown id: err = %v", err)
+	}
+}
@@ -13,33 +13,58 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/JustinBeckwith/go-yelp/yelp"
-	"github.com/guregu/null"
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
 var bot *linebot.Client
 var o *yelp.AuthOptions
-var food = make(map[string]string)
+var sessions SessionStore
+var events *eventQueue
+var dedup *dedupCache
+var history *historyStore
+var profiles *preferenceStore
+var favorites *favoriteStore
+var polls *pollStore
+var subscriptions *subscriptionStore
+var blocks *blockList
+var geocoder Geocoder
+var places PlaceProvider
+var shortener Shortener
+var links *linkStore
 
-type UrlShortener struct {
-	ShortUrl    string
-	OriginalUrl string
-}
+// publicURL is where LINE and users reach this server, from BASE_URL.
+var publicURL string
+
+// richCards sends recommendations as rich message cards (RICH_CARDS=1).
+var richCards bool
+
+// recommender picks which search results get recommended.
+var recommender *sampler
+
+// cards renders the result card images, when publicURL is set.
+var cards *cardCache
+
+// actionSecret signs the links behind card buttons.
+var actionSecret []byte
+
+// localZone is where the bot's users eat, from LUNCH_TZ. Opening hours are
+// read in it.
+var localZone = time.Local
 
 func main() {
-	rand.Seed(time.Now().UnixNano())
 	strID := os.Getenv("ChannelID")
 	numID, err := strconv.ParseInt(strID, 10, 64)
 	if err != nil {
@@ -51,22 +76,233 @@ func main() {
 		log.Fatal("Wrong environment setting about ChannelSecret and MID")
 	}
 
+	geocoder = newGazetteer(taiwanPlaces)
+
 	// check environment variables
-	o = &yelp.AuthOptions{
-		ConsumerKey:       os.Getenv("CONSUMER_KEY"),
-		ConsumerSecret:    os.Getenv("CONSUMER_SECRET"),
-		AccessToken:       os.Getenv("ACCESS_TOKEN"),
-		AccessTokenSecret: os.Getenv("ACCESS_TOKEN_SECRET"),
+	switch os.Getenv("PLACES_PROVIDER") {
+	case "", "yelp":
+		switch os.Getenv("YELP_API_VERSION") {
+		case "", "2":
+			o = &yelp.AuthOptions{
+				ConsumerKey:       os.Getenv("CONSUMER_KEY"),
+				ConsumerSecret:    os.Getenv("CONSUMER_SECRET"),
+				AccessToken:       os.Getenv("ACCESS_TOKEN"),
+				AccessTokenSecret: os.Getenv("ACCESS_TOKEN_SECRET"),
+			}
+
+			if o.ConsumerKey == "" || o.ConsumerSecret == "" || o.AccessToken == "" || o.AccessTokenSecret == "" {
+				log.Fatal("Wrong environment setting about yelp-api-keys")
+			}
+			places = newYelpProvider(o)
+		case "3":
+			// YELP_API_URL points the Fusion client somewhere other than
+			// Yelp, such as a fake server
+			apiKey := os.Getenv("YELP_API_KEY")
+			if apiKey == "" {
+				log.Fatal("Wrong environment setting about YELP_API_KEY")
+			}
+			client := newFusionClient(os.Getenv("YELP_API_URL"), apiKey)
+			places = newFusionProvider(client, os.Getenv("YELP_LOCALE"))
+		default:
+			log.Fatal("Wrong environment setting about YELP_API_VERSION")
+		}
+	case "local":
+		// PLACES_FILE lists the CSV and JSON files to load, separated by commas
+		files := strings.Split(os.Getenv("PLACES_FILE"), ",")
+		if files[0] == "" {
+			log.Fatal("Wrong environment setting about PLACES_FILE")
+		}
+		local, err := openLocalProvider(files, geocoder)
+		if err != nil {
+			log.Fatal(err)
+		}
+		places = local
+	default:
+		log.Fatal("Wrong environment setting about PLACES_PROVIDER")
 	}
 
-	if o.ConsumerKey == "" || o.ConsumerSecret == "" || o.AccessToken == "" || o.AccessTokenSecret == "" {
-		log.Fatal("Wrong environment setting about yelp-api-keys")
+	// a SEARCH_CACHE_TTL of 0 turns the search cache off
+	if ttl := envDuration("SEARCH_CACHE_TTL", 10*time.Minute); ttl > 0 {
+		places = newSearchCache(places, ttl,
+			envDuration("SEARCH_CACHE_STALE", 30*time.Minute),
+			envInt("SEARCH_CACHE_SIZE", 512),
+			envInt("SEARCH_CACHE_PRECISION", 6))
 	}
 
+	sessionTTL := envDuration("SESSION_TTL", 30*time.Minute)
+	dedupTTL := envDuration("DEDUP_TTL", time.Hour)
+	pollDuration := envDuration("POLL_DURATION", 15*time.Minute)
+	lunchZone := os.Getenv("LUNCH_TZ")
+	if lunchZone == "" {
+		lunchZone = "Asia/Taipei"
+	}
+	localZone, err = time.LoadLocation(lunchZone)
+	if err != nil {
+		log.Fatal("Wrong environment setting about LUNCH_TZ")
+	}
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		blocks, err = openBlockList(filepath.Join(dir, "audit.log"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		sessions, err = openSessionStore(filepath.Join(dir, "sessions.log"), sessionTTL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dedup, err = openDedupCache(filepath.Join(dir, "events.log"), dedupTTL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		history, err = openHistoryStore(filepath.Join(dir, "history.log"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		profiles, err = openPreferenceStore(filepath.Join(dir, "preferences.log"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		favorites, err = openFavoriteStore(filepath.Join(dir, "favorites.log"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		polls, err = openPollStore(filepath.Join(dir, "polls.log"), pollDuration, announcePoll)
+		if err != nil {
+			log.Fatal(err)
+		}
+		subscriptions, err = openSubscriptionStore(filepath.Join(dir, "subscriptions.log"), lunchZone)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		blocks = newBlockList()
+		sessions = newMemorySessionStore(sessionTTL)
+		dedup = newDedupCache(dedupTTL)
+		history = newHistoryStore()
+		profiles = newPreferenceStore()
+		favorites = newFavoriteStore()
+		polls = newPollStore(pollDuration, announcePoll)
+		subscriptions = newSubscriptionStore(lunchZone)
+	}
+
+	actionSecret = []byte(os.Getenv("ChannelSecret"))
+	publicURL = strings.TrimRight(os.Getenv("BASE_URL"), "/")
+	if publicURL != "" {
+		if dir := os.Getenv("DATA_DIR"); dir != "" {
+			links, err = openLinkStore(filepath.Join(dir, "links.log"), publicURL)
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			links = newLinkStore(publicURL)
+		}
+		http.Handle("/s/", links)
+		if token := os.Getenv("STATS_TOKEN"); token != "" {
+			http.HandleFunc("/stats/links", links.statsHandler(token))
+		}
+
+		cardDir := os.Getenv("CARD_CACHE_DIR")
+		if cardDir == "" {
+			if dir := os.Getenv("DATA_DIR"); dir != "" {
+				cardDir = filepath.Join(dir, "cards")
+			} else {
+				cardDir = filepath.Join(os.TempDir(), "lineproject-cards")
+			}
+		}
+		// CARD_FONT is a GNU Unifont .hex file, for drawing Chinese names
+		if path := os.Getenv("CARD_FONT"); path != "" {
+			if err := loadHexFont(path); err != nil {
+				log.Fatal(err)
+			}
+		}
+		cards = newCardCache(cardDir, "/card/", renderCard)
+		http.Handle("/card/", cards)
+
+		richCards = os.Getenv("RICH_CARDS") == "1"
+		if richCards {
+			http.Handle("/rich/", newCardCache(filepath.Join(cardDir, "rich"), "/rich/", renderRichImage))
+			http.HandleFunc("/more", moreHandler)
+			http.HandleFunc("/save", saveHandler)
+		}
+	}
+	chain, err := newShortenerChain(os.Getenv("SHORTENERS"), links)
+	if err != nil {
+		log.Fatal(err)
+	}
+	shortCacheSize := envInt("SHORTURL_CACHE_SIZE", 1024)
+	if dir := os.Getenv("DATA_DIR"); dir != "" {
+		shortener, err = openShortURLCache(filepath.Join(dir, "shorturls.log"), chain, shortCacheSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		shortener = newShortURLCache(chain, shortCacheSize)
+	}
+
+	seed := time.Now().UnixNano()
+	if v := os.Getenv("SAMPLE_SEED"); v != "" {
+		seed, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatal("Wrong environment setting about SAMPLE_SEED")
+		}
+	}
+	recommender, err = newSampler(sampleStrategy(os.Getenv("SAMPLE_STRATEGY")), seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	events = newEventQueue(envInt("WORKERS", 4), envInt("QUEUE_SIZE", 256), handleEvent)
+	polls.Run()
+	subscriptions.Run()
+
 	http.HandleFunc("/callback", callbackHandler)
 	port := os.Getenv("PORT")
 	addr := fmt.Sprintf(":%s", port)
-	http.ListenAndServe(addr, nil)
+	server := &http.Server{Addr: addr}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// stop taking webhooks, then let the workers finish what was accepted
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	drain := envDuration("DRAIN_TIMEOUT", 20*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+	if err := events.Close(drain); err != nil {
+		log.Println(err)
+	}
+	subscriptions.Close()
+}
+
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatal("Wrong environment setting about " + name)
+	}
+	return n
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatal("Wrong environment setting about " + name)
+	}
+	return d
 }
 
 func callbackHandler(w http.ResponseWriter, r *http.Request) {
@@ -80,155 +316,51 @@ func callbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// create a new yelp client with the auth keys
-	client := yelp.New(o, nil)
-
-	for _, result := range received.Results {
-		content := result.Content()
-
-		//identify different ContentType
-		if content != nil && content.IsOperation && content.OpType == 4 {
-			//add new friend
-			_, err := bot.SendText([]string{result.RawContent.Params[0]}, "Hi~\n歡迎加入 Delicious!\n\n想查詢附近或各地美食都可以LINE我呦！\n\n請問你想吃什麼?\nex:義大利麵\n\n想不到吃什麼，也可以直接'傳送目前位置訊息'")
-			var img = "http://imageshack.com/a/img921/318/DC21al.png"
-			_, err = bot.SendImage([]string{content.From}, img, img)
-			if err != nil {
-				log.Println(err)
-			}
-		} else if content != nil && content.ContentType == linebot.ContentTypeLocation {
-			//receive location
-			loc, err := content.LocationContent()
-			if err != nil {
-				log.Println(err)
-			}
-
-			if food[content.From] == "" {
-				//_, err = bot.SendText([]string{content.From},"想不到吃什麼，也可以直接'傳送目前位置訊息'")
-				food[content.From] = "food,restaurants"
-			}
-
-			// Build an advanced set of search criteria that include
-			// general options, and coordinate options.
-			s := yelp.SearchOptions{
-				GeneralOptions: &yelp.GeneralOptions{
-					Term: food[content.From],
-				},
-				CoordinateOptions: &yelp.CoordinateOptions{
-					Latitude:  null.FloatFrom(loc.Latitude),
-					Longitude: null.FloatFrom(loc.Longitude),
-				},
-			}
-
-			// Perform the search using the search options
-			results, err := client.DoSearch(s)
-			if err != nil {
-				log.Println(err)
-				_, err = bot.SendText([]string{content.From}, "查無資料！\n請重新輸入\n\n請問你想吃什麼?\nex:義大利麵\n\n想不到吃什麼，也可以直接'傳送目前位置訊息'\nex：")
-				var img = "http://imageshack.com/a/img921/318/DC21al.png"
-				_, err = bot.SendImage([]string{content.From}, img, img)
-				delete(food, content.From)
-			}
-
-			for j := 0; j < 3; j++ {
-				i := 0
-				if results.Total >= 16 {
-					i = rand.Intn(16)
-				} else if results.Total >= 8 {
-					i = rand.Intn(8)
-				} else if results.Total > j {
-					i = j
-				} else if results.Total <= j && results.Total != 0 {
-					_, err = bot.SendText([]string{content.From}, "已無更多資料！")
-					break
-				}
-				urlOrig := UrlShortener{}
-				urlOrig.short(results.Businesses[i].MobileURL)
-				address := strings.Join(results.Businesses[i].Location.DisplayAddress, ",")
-				var largeImageURL = strings.Replace(results.Businesses[i].ImageURL, "ms.jpg", "l.jpg", 1)
-
-				_, err = bot.SendImage([]string{content.From}, largeImageURL, largeImageURL)
-				_, err = bot.SendText([]string{content.From}, "店名："+results.Businesses[i].Name+"\n電話："+results.Businesses[i].Phone+"\n評比："+strconv.FormatFloat(float64(results.Businesses[i].Rating), 'f', 1, 64)+"\n更多資訊："+urlOrig.ShortUrl)
-				_, err = bot.SendLocation([]string{content.From}, results.Businesses[i].Name+"\n", address, float64(results.Businesses[i].Location.Coordinate.Latitude), float64(results.Businesses[i].Location.Coordinate.Longitude))
-			}
-			_, err = bot.SendText([]string{content.From}, "請問你想吃什麼?\nex:義大利麵\n\n想不到吃什麼，也可以直接'傳送目前位置訊息'\nex：")
-			var img = "http://imageshack.com/a/img921/318/DC21al.png"
-			_, err = bot.SendImage([]string{content.From}, img, img)
-			delete(food, content.From)
-		} else if content != nil && content.IsMessage && content.ContentType == linebot.ContentTypeText {
-			//receive text
-			text, err := content.TextContent()
-			if err != nil {
-				log.Println(err)
-			}
-			log.Println("food: " + food[content.From])
-			if food[content.From] == "" {
-				food[content.From] = text.Text
-				_, err := bot.SendText([]string{content.From}, "你在哪裡?\n請'手動輸入目前位置'\nex:台北市信義區...\n或是利用'傳送目前位置訊息'\nex：")
-				var img = "http://imageshack.com/a/img921/318/DC21al.png"
-				_, err = bot.SendImage([]string{content.From}, img, img)
-				if err != nil {
-					log.Println(err)
-				}
-			} else {
-				// make a simple query for food and location
-				results, err := client.DoSimpleSearch(food[content.From], text.Text)
-				if err != nil {
-					log.Println(err)
-					_, err = bot.SendText([]string{content.From}, "查無資料！\n請重新輸入\n\n請問你想吃什麼?\nex:義大利麵\n\n不知道吃什麼\n可以直接'傳送目前位置訊息'\nex:")
-					var img = "http://imageshack.com/a/img921/318/DC21al.png"
-					_, err = bot.SendImage([]string{content.From}, img, img)
-					delete(food, content.From)
-				}
-
-				for j := 0; j < 3; j++ {
-					i := 0
-					if results.Total >= 20 {
-						i = rand.Intn(20)
-					} else if results.Total >= 10 {
-						i = rand.Intn(10)
-					} else if results.Total > j {
-						i = j
-					} else if results.Total <= j && results.Total != 0 {
-						_, err = bot.SendText([]string{content.From}, "已無更多資料！")
-						break
-					}
-					urlOrig := UrlShortener{}
-					urlOrig.short(results.Businesses[i].MobileURL)
-					address := strings.Join(results.Businesses[i].Location.DisplayAddress, ",")
-					var largeImageURL = strings.Replace(results.Businesses[i].ImageURL, "ms.jpg", "l.jpg", 1)
-
-					_, err = bot.SendImage([]string{content.From}, largeImageURL, largeImageURL)
-					_, err = bot.SendText([]string{content.From}, "店名："+results.Businesses[i].Name+"\n電話："+results.Businesses[i].Phone+"\n評比："+strconv.FormatFloat(float64(results.Businesses[i].Rating), 'f', 1, 64)+"\n更多資訊："+urlOrig.ShortUrl)
-					_, err = bot.SendLocation([]string{content.From}, results.Businesses[i].Name+"\n", address, float64(results.Businesses[i].Location.Coordinate.Latitude), float64(results.Businesses[i].Location.Coordinate.Longitude))
-				}
-				_, err = bot.SendText([]string{content.From}, "請問你想吃什麼?\nex:義大利麵\n\n不知道吃什麼\n可以直接'傳送目前位置訊息'\nex:")
-				var img = "http://imageshack.com/a/img921/318/DC21al.png"
-				_, err = bot.SendImage([]string{content.From}, img, img)
-				delete(food, content.From)
-			}
+	for i := range received.Results {
+		result := &received.Results[i]
+		keys := eventKeys(result)
+		if !dedup.Claim(keys...) {
+			log.Printf("dropping duplicate event %s", result.ID)
+			continue
+		}
+		if err := events.Enqueue(result); err != nil {
+			// ask LINE to redeliver rather than drop the event
+			log.Println(err)
+			dedup.Release(keys...)
+			w.WriteHeader(503)
+			return
 		}
 	}
+	w.WriteHeader(200)
 }
 
-func getResponseData(urlOrig string) string {
-	response, err := http.Get(urlOrig)
-	if err != nil {
-		log.Println(err)
+// eventUser returns the LINE user an event is about. Operations carry the
+// user in their params rather than in From.
+func eventUser(result *linebot.ReceivedResult) string {
+	if result.EventType == linebot.EventTypeReceivingOperation && len(result.RawContent.Params) > 0 {
+		return result.RawContent.Params[0]
 	}
-	defer response.Body.Close()
-	contents, err := ioutil.ReadAll(response.Body)
-	return string(contents)
+	return result.RawContent.From
 }
 
-func isGdShortener(urlOrig string) (string, string) {
-	escapedUrl := url.QueryEscape(urlOrig)
-	isGdUrl := fmt.Sprintf("http://is.gd/create.php?url=%s&format=simple", escapedUrl)
-	return getResponseData(isGdUrl), urlOrig
-}
+func handleEvent(result *linebot.ReceivedResult) {
+	user := eventUser(result)
+	content := result.Content()
+	// messages still queued from before a block are dropped
+	if blocks.Blocked(user) && !content.IsOperation {
+		return
+	}
+	session, _ := sessions.Get(user)
 
-func (u *UrlShortener) short(urlOrig string) *UrlShortener {
-	shortUrl, originalUrl := isGdShortener(urlOrig)
-	u.ShortUrl = shortUrl
-	u.OriginalUrl = originalUrl
-	return u
+	err := foodDialog.Dispatch(&dialogContext{
+		User:    user,
+		Result:  result,
+		Content: content,
+		Session: &session,
+		Places:  places,
+		Reply:   newReply(user),
+	})
+	if err != nil {
+		log.Println(err)
+	}
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// pageSize is how many businesses one Yelp request asks for.
+	pageSize = 20
+	// resultWindow is how deep into a result list Yelp lets us page.
+	resultWindow = 40
+)
+
+const (
+	promptMore      = "想看更多，請回覆「更多」"
+	promptExhausted = "已經沒有更多結果了！\n\n" + promptFood
+)
+
+var errResultsExhausted = errors.New("no more results")
+
+// moreWords are the replies that ask for the next recommendations.
+var moreWords = []string{"更多", "more", "next", "下一頁", "再來"}
+
+func isMoreRequest(text string) bool {
+	return containsString(moreWords, strings.ToLower(strings.TrimSpace(text)))
+}
+
+// lastSearch is the search a user can page through with "更多".
+type lastSearch struct {
+	Query       string   `json:"query"`
+	Location    string   `json:"location,omitempty"`
+	Coordinates bool     `json:"coordinates,omitempty"`
+	Latitude    float64  `json:"latitude,omitempty"`
+	Longitude   float64  `json:"longitude,omitempty"`
+	Offset      int      `json:"offset"`
+	Total       int      `json:"total"`
+	Shown       []string `json:"shown,omitempty"`
+}
+
+func (s *lastSearch) query() PlaceQuery {
+	q := parseQuery(s.Query).PlaceQuery()
+	q.Limit = pageSize
+	q.Offset = s.Offset
+	return q
+}
+
+// search runs q around where s was made.
+func (s *lastSearch) search(provider PlaceProvider, q PlaceQuery) (PlaceResults, error) {
+	if s.Coordinates {
+		return provider.SearchByCoordinate(q, s.Latitude, s.Longitude)
+	}
+	// a geocoded location keeps its coordinates as a hint
+	var hint *LatLng
+	if s.Latitude != 0 || s.Longitude != 0 {
+		hint = &LatLng{Latitude: s.Latitude, Longitude: s.Longitude}
+	}
+	return provider.SearchByText(q, s.Location, hint)
+}
+
+// exhausted reports whether paging further cannot return anything new.
+func (s *lastSearch) exhausted() bool {
+	return s.Offset >= resultWindow || (s.Total > 0 && s.Offset >= s.Total)
+}
+
+// showSearch adds the next businesses of s the user has not seen yet to the
+// reply, moving on to the following page once the current one is used up.
+func showSearch(c *dialogContext, s *lastSearch) error {
+	for {
+		if s.exhausted() {
+			return errResultsExhausted
+		}
+		prefs := profiles.Get(c.User)
+		q := s.query()
+		prefs.apply(&q)
+		results, err := s.search(c.Places, q)
+		if err != nil {
+			return err
+		}
+		s.Total = results.Total
+
+		var fresh []Place
+		for _, b := range results.Places {
+			if !containsString(s.Shown, b.ID) && prefs.allows(b) {
+				fresh = append(fresh, b)
+			}
+		}
+		if len(fresh) == 0 {
+			if len(results.Places) == 0 {
+				return errResultsExhausted
+			}
+			s.Offset += len(results.Places)
+			continue
+		}
+
+		// what is left to page through after this one counts towards Total,
+		// so showResults only says there is no more when that is true
+		later := s.Total
+		if later > resultWindow {
+			later = resultWindow
+		}
+		later -= s.Offset + len(results.Places)
+		if later < 0 {
+			later = 0
+		}
+		shown := showResults(c, PlaceResults{Total: len(fresh) + later, Places: fresh})
+		for _, b := range shown {
+			if !containsString(s.Shown, b.ID) {
+				s.Shown = append(s.Shown, b.ID)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/guregu/null"
+)
+
+// errPlaceNotFound is what a PlaceProvider returns from Details for an ID it
+// does not know.
+var errPlaceNotFound = errors.New("place not found")
+
+// errUnknownLocation is what a PlaceProvider returns from SearchByText for a
+// location it cannot place.
+var errUnknownLocation = errors.New("unknown location")
+
+// PlaceProvider is a source of restaurants. The conversation, ranking and
+// rendering only ever see Places, so sources can be added or swapped here.
+type PlaceProvider interface {
+	// SearchByCoordinate searches around a point.
+	SearchByCoordinate(q PlaceQuery, latitude, longitude float64) (PlaceResults, error)
+	// SearchByText searches around a place the user typed. hint, if not
+	// nil, is where the text was geocoded to.
+	SearchByText(q PlaceQuery, location string, hint *LatLng) (PlaceResults, error)
+	// Details returns everything the provider knows about a place.
+	Details(id string) (Place, error)
+}
+
+// termSuggester is a PlaceProvider that can suggest search terms for what a
+// user typed, near a point when near is not nil.
+type termSuggester interface {
+	SuggestTerms(text string, near *LatLng) ([]string, error)
+}
+
+// LatLng is a point on the map.
+type LatLng struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// PlaceQuery is what to search for. Without a term or category it asks for
+// restaurants in general.
+type PlaceQuery struct {
+	Term       string
+	Categories []string
+	// Radius is in meters.
+	Radius null.Float
+	// Sort is one of sortBestMatched, sortDistance and sortHighestRate.
+	Sort  null.Int
+	Deals null.Bool
+	// OpenNow keeps only places open at the time of the search, and OpenAt,
+	// when set, those open at that time. Providers that do not know opening
+	// hours ignore both.
+	OpenNow bool
+	OpenAt  time.Time
+	// MaxPrice is the most dollar signs to pay, 0 for any. Providers that
+	// cannot filter by price ignore it.
+	MaxPrice int
+	Limit    int
+	Offset   int
+}
+
+// PlaceResults is one page of a search. Total counts every match, not only
+// the ones on this page.
+type PlaceResults struct {
+	Total  int
+	Places []Place
+}
+
+// Place is a restaurant, whichever provider it came from. Search results may
+// leave the detail fields empty; Details fills them.
+type Place struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	URL         string          `json:"url,omitempty"`
+	PhotoURL    string          `json:"photo_url,omitempty"`
+	Phone       string          `json:"phone,omitempty"`
+	Rating      float64         `json:"rating,omitempty"`
+	ReviewCount int             `json:"review_count,omitempty"`
+	Categories  []PlaceCategory `json:"categories,omitempty"`
+	// Price is how many dollar signs the place costs, 0 when unknown.
+	Price int `json:"price,omitempty"`
+	// Distance is in meters from where the search was made, 0 when unknown.
+	Distance  float64  `json:"distance,omitempty"`
+	Address   []string `json:"address,omitempty"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+
+	IsClosed         bool              `json:"is_closed,omitempty"`
+	CrossStreets     string            `json:"cross_streets,omitempty"`
+	Neighborhoods    []string          `json:"neighborhoods,omitempty"`
+	Snippet          string            `json:"snippet,omitempty"`
+	Hours            []PlaceHours      `json:"hours,omitempty"`
+	Reviews          []PlaceReview     `json:"reviews,omitempty"`
+	Deals            []PlaceDeal       `json:"deals,omitempty"`
+	GiftCertificates []PlaceGiftOption `json:"gift_certificates,omitempty"`
+}
+
+// PlaceCategory is a kind of food. Alias is what searches filter on.
+type PlaceCategory struct {
+	Name  string `json:"name"`
+	Alias string `json:"alias"`
+}
+
+// PlaceHours is one opening on Day, 0 being Monday. Start and End are local
+// times written as "1130"; End may be past midnight.
+type PlaceHours struct {
+	Day   int    `json:"day"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// PlaceReview is an excerpt of one review.
+type PlaceReview struct {
+	Rating  float64 `json:"rating"`
+	Excerpt string  `json:"excerpt"`
+	Author  string  `json:"author,omitempty"`
+}
+
+// PlaceDeal is an offer with its prices formatted for display.
+type PlaceDeal struct {
+	Title   string       `json:"title"`
+	Options []PlaceOffer `json:"options,omitempty"`
+}
+
+// PlaceOffer is one way to buy a deal.
+type PlaceOffer struct {
+	Title         string `json:"title,omitempty"`
+	Price         string `json:"price"`
+	OriginalPrice string `json:"original_price,omitempty"`
+}
+
+// PlaceGiftOption lists the formatted prices of one gift certificate.
+type PlaceGiftOption struct {
+	Prices []string `json:"prices"`
+}
+
+// CategoryAliases returns the aliases of p's categories.
+func (p Place) CategoryAliases() []string {
+	var aliases []string
+	for _, c := range p.Categories {
+		if c.Alias != "" {
+			aliases = append(aliases, c.Alias)
+		}
+	}
+	return aliases
+}
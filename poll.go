@@ -0,0 +1,464 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pollSize is how many restaurants a lunch poll offers.
+	pollSize = 4
+
+	pollCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	pollCodeLength   = 4
+)
+
+const (
+	promptPollHelp     = "揪團吃飯：回覆「揪團 拉麵 @ 信義區」開始投票"
+	promptPollFailed   = "找不到可以投票的餐廳，換個食物或地點試試！"
+	promptNoSuchPoll   = "找不到這個投票，請確認代碼"
+	promptPollJoined   = "已加入投票！回覆號碼投票"
+	promptPollLeft     = "已退出投票"
+	promptNotInPoll    = "你目前沒有參加投票"
+	promptPollNotOwner = "只有發起人可以提早結束投票"
+	promptNoVotes      = "投票結束，沒有人投票！"
+)
+
+var (
+	startPollPattern = regexp.MustCompile(`(?i)^\s*(?:揪團|poll)\s*(.+?)\s*(?:@|＠|\s在\s?|\sat\s)\s*(.+?)\s*$`)
+	joinPollPattern  = regexp.MustCompile(`(?i)^\s*(?:加入|join)\s*([a-z0-9]{4})\s*$`)
+	votePattern      = regexp.MustCompile(`^\s*([1-9])\s*$`)
+)
+
+var errPollClosed = errors.New("poll: closed")
+
+// pollOption is one restaurant members can vote for.
+type pollOption struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Address   string  `json:"address,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+// poll is a group deciding on lunch. The trial API only delivers one-to-one
+// messages, so the group is whoever joined with the poll's code, and every
+// announcement goes to each member.
+type poll struct {
+	Code     string         `json:"code"`
+	Owner    string         `json:"owner"`
+	Food     string         `json:"food"`
+	Location string         `json:"location"`
+	Options  []pollOption   `json:"options"`
+	Members  []string       `json:"members"`
+	Votes    map[string]int `json:"votes,omitempty"`
+	Deadline time.Time      `json:"deadline"`
+}
+
+func (p *poll) ballot() string {
+	lines := []string{"午餐投票 " + p.Code + "：" + p.Food + " @ " + p.Location}
+	for i, opt := range p.Options {
+		lines = append(lines, strconv.Itoa(i+1)+". "+opt.Name)
+	}
+	lines = append(lines, "", "回覆號碼投票，"+p.Deadline.Format("15:04")+" 截止")
+	return strings.Join(lines, "\n")
+}
+
+// winner returns the option with the most votes, the earlier listed one on a
+// tie, and false if nobody voted.
+func (p *poll) winner() (pollOption, int, bool) {
+	counts := make([]int, len(p.Options))
+	for _, choice := range p.Votes {
+		counts[choice]++
+	}
+	best := -1
+	for i, n := range counts {
+		if n > 0 && (best < 0 || n > counts[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return pollOption{}, 0, false
+	}
+	return p.Options[best], counts[best], true
+}
+
+func (p *poll) copy() *poll {
+	c := *p
+	c.Options = append([]pollOption(nil), p.Options...)
+	c.Members = append([]string(nil), p.Members...)
+	c.Votes = make(map[string]int, len(p.Votes))
+	for user, choice := range p.Votes {
+		c.Votes[user] = choice
+	}
+	return &c
+}
+
+type pollRecord struct {
+	Poll   *poll  `json:"poll,omitempty"`
+	Remove string `json:"remove,omitempty"`
+}
+
+// pollStore holds the open polls and closes each at its deadline, or as soon
+// as every member has voted.
+type pollStore struct {
+	mu       sync.Mutex
+	duration time.Duration
+	polls    map[string]*poll
+	// members maps a user to the code of the poll they are in
+	members map[string]string
+	timers  map[string]*time.Timer
+	onClose func(*poll)
+	journal *journal
+}
+
+func newPollStore(duration time.Duration, onClose func(*poll)) *pollStore {
+	return &pollStore{
+		duration: duration,
+		polls:    make(map[string]*poll),
+		members:  make(map[string]string),
+		timers:   make(map[string]*time.Timer),
+		onClose:  onClose,
+	}
+}
+
+// openPollStore returns a pollStore journaled to path. The polls it loads
+// are not timed until Run.
+func openPollStore(path string, duration time.Duration, onClose func(*poll)) (*pollStore, error) {
+	s := newPollStore(duration, onClose)
+	j, err := openJournal(path, func(line []byte) error {
+		var r pollRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if r.Poll != nil {
+			if r.Poll.Votes == nil {
+				r.Poll.Votes = make(map[string]int)
+			}
+			s.polls[r.Poll.Code] = r.Poll
+		} else {
+			delete(s.polls, r.Remove)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for code, p := range s.polls {
+		for _, user := range p.Members {
+			s.members[user] = code
+		}
+	}
+	if err := j.Compact(s.live()); err != nil {
+		j.Close()
+		return nil, err
+	}
+	s.journal = j
+	return s, nil
+}
+
+// Run starts the deadlines of the polls loaded from the journal. Polls whose
+// deadline passed while the bot was down are closed right away, so onClose
+// must be ready to announce them.
+func (s *pollStore) Run() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for code, p := range s.polls {
+		if s.timers[code] == nil {
+			s.schedule(p)
+		}
+	}
+}
+
+// live returns every open poll as a record. Callers hold s.mu, or own s
+// exclusively.
+func (s *pollStore) live() []interface{} {
+	var live []interface{}
+	for _, p := range s.polls {
+		live = append(live, pollRecord{Poll: p})
+	}
+	return live
+}
+
+// append journals r. Callers hold s.mu.
+func (s *pollStore) append(r pollRecord) {
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.Append(r); err != nil {
+		log.Println(err)
+	}
+	if err := s.journal.CompactIfDue(s.live); err != nil {
+		log.Println(err)
+	}
+}
+
+// schedule closes p at its deadline. Callers hold s.mu, or own s exclusively.
+func (s *pollStore) schedule(p *poll) {
+	code := p.Code
+	s.timers[code] = time.AfterFunc(time.Until(p.Deadline), func() {
+		s.Close(code)
+	})
+}
+
+func newPollCode() (string, error) {
+	max := big.NewInt(int64(len(pollCodeAlphabet)))
+	code := make([]byte, pollCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = pollCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Start opens a poll owned by owner, who leaves any poll they were in.
+func (s *pollStore) Start(owner, food, location string, options []pollOption) (*poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var code string
+	for code == "" || s.polls[code] != nil {
+		var err error
+		if code, err = newPollCode(); err != nil {
+			return nil, err
+		}
+	}
+	s.leave(owner)
+	p := &poll{
+		Code:     code,
+		Owner:    owner,
+		Food:     food,
+		Location: location,
+		Options:  options,
+		Members:  []string{owner},
+		Votes:    make(map[string]int),
+		Deadline: time.Now().Add(s.duration).Truncate(time.Second),
+	}
+	s.polls[code] = p
+	s.members[owner] = code
+	s.append(pollRecord{Poll: p})
+	s.schedule(p)
+	return p.copy(), nil
+}
+
+// Join adds user to the poll with code.
+func (s *pollStore) Join(user, code string) (*poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.polls[code]
+	if p == nil {
+		return nil, errPollClosed
+	}
+	if s.members[user] != code {
+		s.leave(user)
+		p.Members = append(p.Members, user)
+		s.members[user] = code
+		s.append(pollRecord{Poll: p})
+	}
+	return p.copy(), nil
+}
+
+// Leave takes user out of their poll, dropping their vote.
+func (s *pollStore) Leave(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leave(user)
+}
+
+func (s *pollStore) leave(user string) bool {
+	p := s.polls[s.members[user]]
+	delete(s.members, user)
+	if p == nil {
+		return false
+	}
+	for i, member := range p.Members {
+		if member == user {
+			p.Members = append(p.Members[:i], p.Members[i+1:]...)
+			break
+		}
+	}
+	delete(p.Votes, user)
+	s.append(pollRecord{Poll: p})
+	return true
+}
+
+// Of returns the poll user is in.
+func (s *pollStore) Of(user string) (*poll, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.polls[s.members[user]]
+	if p == nil {
+		return nil, false
+	}
+	return p.copy(), true
+}
+
+// Vote records user's choice, an index into the options, and closes the poll
+// once every member has voted.
+func (s *pollStore) Vote(user string, choice int) (*poll, error) {
+	s.mu.Lock()
+	p := s.polls[s.members[user]]
+	if p == nil {
+		s.mu.Unlock()
+		return nil, errPollClosed
+	}
+	p.Votes[user] = choice
+	s.append(pollRecord{Poll: p})
+	snapshot := p.copy()
+	everyone := len(p.Votes) >= len(p.Members)
+	s.mu.Unlock()
+
+	if everyone {
+		s.Close(p.Code)
+	}
+	return snapshot, nil
+}
+
+// Close ends the poll with code and hands it to onClose. Closing a poll that
+// is already closed does nothing, so the deadline and the last vote cannot
+// both announce it.
+func (s *pollStore) Close(code string) {
+	s.mu.Lock()
+	p := s.polls[code]
+	if p == nil {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.polls, code)
+	for _, user := range p.Members {
+		if s.members[user] == code {
+			delete(s.members, user)
+		}
+	}
+	if t := s.timers[code]; t != nil {
+		t.Stop()
+		delete(s.timers, code)
+	}
+	s.append(pollRecord{Remove: code})
+	s.mu.Unlock()
+
+	s.onClose(p)
+}
+
+// pollCommand starts, joins, votes in and closes lunch polls.
+func pollCommand(c *dialogContext, text string) (DialogState, bool) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "揪團", "poll":
+		c.Reply.Text(promptPollHelp)
+		return "", true
+	case "退出投票", "leave poll":
+		if polls.Leave(c.User) {
+			c.Reply.Text(promptPollLeft)
+		} else {
+			c.Reply.Text(promptNotInPoll)
+		}
+		return "", true
+	case "結束投票", "close poll":
+		p, ok := polls.Of(c.User)
+		switch {
+		case !ok:
+			c.Reply.Text(promptNotInPoll)
+		case p.Owner != c.User:
+			c.Reply.Text(promptPollNotOwner)
+		default:
+			polls.Close(p.Code)
+		}
+		return "", true
+	}
+
+	if m := startPollPattern.FindStringSubmatch(text); m != nil {
+		startPoll(c, m[1], m[2])
+		return "", true
+	}
+	if m := joinPollPattern.FindStringSubmatch(text); m != nil {
+		p, err := polls.Join(c.User, strings.ToUpper(m[1]))
+		if err != nil {
+			c.Reply.Text(promptNoSuchPoll)
+			return "", true
+		}
+		c.Reply.Text(promptPollJoined).Text(p.ballot())
+		return "", true
+	}
+	// a number answers the place question first, if one is open
+	if m := votePattern.FindStringSubmatch(text); m != nil && c.Session.State != StateChoosingPlace {
+		p, ok := polls.Of(c.User)
+		if !ok {
+			return "", false
+		}
+		choice := int(m[1][0] - '1')
+		if choice >= len(p.Options) {
+			c.Reply.Text(promptNoSuchPlace)
+			return "", true
+		}
+		if p, err := polls.Vote(c.User, choice); err == nil {
+			c.Reply.Text("已投給 " + p.Options[choice].Name + "（" + strconv.Itoa(len(p.Votes)) + "/" + strconv.Itoa(len(p.Members)) + " 人已投票）")
+		}
+		return "", true
+	}
+	return "", false
+}
+
+// startPoll searches food around location and opens a poll over a few of the
+// results.
+func startPoll(c *dialogContext, food, location string) {
+	q := parseQuery(food).PlaceQuery()
+	q.Limit = pageSize
+	// the group eats once the poll closes
+	q.OpenAt = time.Now().Add(polls.duration)
+	profiles.Get(c.User).apply(&q)
+	results, err := c.Places.SearchByText(q, location, nil)
+	if err != nil {
+		log.Println(err)
+		c.Reply.Text(promptPollFailed)
+		return
+	}
+
+	var options []pollOption
+	for _, b := range recommender.Sample(results.Places, pollSize) {
+		options = append(options, pollOption{
+			ID:        b.ID,
+			Name:      b.Name,
+			Address:   strings.Join(b.Address, ","),
+			Latitude:  b.Latitude,
+			Longitude: b.Longitude,
+		})
+	}
+	if len(options) < 2 {
+		c.Reply.Text(promptPollFailed)
+		return
+	}
+	p, err := polls.Start(c.User, food, location, options)
+	if err != nil {
+		log.Println(err)
+		c.Reply.Text(promptPollFailed)
+		return
+	}
+	c.Reply.Text(p.ballot()).Text("請朋友加入這個官方帳號後回覆「加入 " + p.Code + "」一起投票")
+}
+
+// announcePoll tells every member of a closed poll where lunch is.
+func announcePoll(p *poll) {
+	for _, user := range p.Members {
+		r := newReply(user)
+		if opt, votes, ok := p.winner(); ok {
+			r.Text("投票結束！" + p.Code + " 的午餐是 " + opt.Name + "（" + strconv.Itoa(votes) + " 票）")
+			r.Location(opt.Name, opt.Address, opt.Latitude, opt.Longitude)
+		} else {
+			r.Text(promptNoVotes)
+		}
+		if err := r.Send(); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPollStoreRestoresExpiredPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "polls.log")
+
+	// a poll whose deadline passed while the bot was down
+	j, err := openJournal(path, func([]byte) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Append(pollRecord{Poll: &poll{
+		Code:     "ABCD",
+		Owner:    "alice",
+		Food:     "拉麵",
+		Location: "信義區",
+		Options:  []pollOption{{ID: "a", Name: "一蘭"}, {ID: "b", Name: "一風堂"}},
+		Members:  []string{"alice", "bob"},
+		Votes:    map[string]int{"alice": 1},
+		Deadline: time.Now().Add(-time.Hour),
+	}})
+	j.Close()
+
+	closed := make(chan *poll, 1)
+	s, err := openPollStore(path, time.Minute, func(p *poll) { closed <- p })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := s.Of("bob"); !ok || p.Code != "ABCD" || p.Votes["alice"] != 1 {
+		t.Fatalf("restored poll = %+v, %v", p, ok)
+	}
+	select {
+	case p := <-closed:
+		t.Fatalf("poll %s closed before Run", p.Code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.Run()
+	select {
+	case p := <-closed:
+		if winner, votes, ok := p.winner(); !ok || winner.ID != "b" || votes != 1 {
+			t.Errorf("winner = %+v with %d votes", winner, votes)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expired poll was not closed by Run")
+	}
+	if _, ok := s.Of("alice"); ok {
+		t.Error("alice is still in the closed poll")
+	}
+
+	// the close was journaled, so a restart does not announce it again
+	s, err = openPollStore(path, time.Minute, func(p *poll) { closed <- p })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Of("bob"); ok {
+		t.Error("closed poll came back after a restart")
+	}
+}
+
+func testPollOptions() []pollOption {
+	return []pollOption{{ID: "a", Name: "一蘭"}, {ID: "b", Name: "一風堂"}, {ID: "c", Name: "屯京"}}
+}
+
+func TestPollVoting(t *testing.T) {
+	var closed []*poll
+	s := newPollStore(time.Hour, func(p *poll) { closed = append(closed, p) })
+	p, err := s.Start("alice", "拉麵", "信義區", testPollOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Join("bob", p.Code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Join("carol", p.Code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Join("dave", "ZZZZ"); err != errPollClosed {
+		t.Errorf("joining an unknown code: %v", err)
+	}
+	if _, err := s.Vote("dave", 0); err != errPollClosed {
+		t.Errorf("vote from a non-member: %v", err)
+	}
+
+	s.Vote("alice", 2)
+	s.Vote("carol", 0)
+	if len(closed) != 0 {
+		t.Fatal("poll closed before everyone voted")
+	}
+	// carol leaving drops her vote and takes her out of the count
+	s.Leave("carol")
+	if got, _ := s.Of("alice"); got == nil || len(got.Votes) != 1 || len(got.Members) != 2 {
+		t.Fatalf("after carol left: %+v", got)
+	}
+	s.Vote("bob", 1)
+	if len(closed) != 1 {
+		t.Fatalf("poll closed %d times once everyone voted", len(closed))
+	}
+	// a tie goes to the option listed first
+	if winner, votes, ok := closed[0].winner(); !ok || winner.ID != "b" || votes != 1 {
+		t.Errorf("winner = %+v with %d votes", winner, votes)
+	}
+
+	if _, err := s.Vote("alice", 0); err != errPollClosed {
+		t.Errorf("vote after close: %v", err)
+	}
+	s.Close(p.Code)
+	if len(closed) != 1 {
+		t.Error("closing a closed poll announced it again")
+	}
+}
+
+func TestPollMembership(t *testing.T) {
+	s := newPollStore(time.Hour, func(*poll) {})
+	first, _ := s.Start("alice", "拉麵", "信義區", testPollOptions())
+	s.Join("bob", first.Code)
+	s.Vote("bob", 0)
+
+	// starting or joining another poll leaves the first, vote and all
+	second, _ := s.Start("bob", "火鍋", "大安區", testPollOptions())
+	if p, _ := s.Of("alice"); len(p.Members) != 1 || len(p.Votes) != 0 {
+		t.Errorf("first poll after bob left: %+v", p)
+	}
+	s.Join("alice", second.Code)
+	if p, ok := s.Of("alice"); !ok || p.Code != second.Code {
+		t.Errorf("alice is in %+v", p)
+	}
+	// the first poll is left empty, not closed
+	if _, err := s.Join("carol", first.Code); err != nil {
+		t.Errorf("joining the empty poll: %v", err)
+	}
+	if s.Leave("dave") {
+		t.Error("dave left a poll they were never in")
+	}
+}
+
+func TestPollDeadline(t *testing.T) {
+	closed := make(chan *poll, 1)
+	s := newPollStore(10*time.Millisecond, func(p *poll) { closed <- p })
+	p, _ := s.Start("alice", "拉麵", "信義區", testPollOptions())
+	select {
+	case got := <-closed:
+		if got.Code != p.Code {
+			t.Errorf("closed %s, want %s", got.Code, p.Code)
+		}
+		if _, _, ok := got.winner(); ok {
+			t.Error("a poll nobody voted in has a winner")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("poll was not closed at its deadline")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	promptPreferencesHelp = "設定偏好範例：\n設定 素食\n設定 清真\n設定 不吃牛\n設定 預算 $$\n設定 喜歡 拉麵\n\n回覆「偏好」查看，「清除偏好」全部清除"
+	promptNoPreferences   = "你還沒有設定偏好\n\n" + promptPreferencesHelp
+	promptClearedPrefs    = "已清除你的偏好！"
+)
+
+// the Yelp categories a business needs to suit each diet
+var (
+	vegetarianCategories = []string{"vegetarian", "vegan"}
+	halalCategories      = []string{"halal"}
+)
+
+// avoidCategories maps what a user does not eat to the Yelp categories that
+// are built around it.
+var avoidCategories = map[string][]string{
+	"beef":    {"steak", "steakhouses", "burgers", "bbq"},
+	"pork":    {"hotdogs", "bbq"},
+	"seafood": {"seafood", "sushi", "fishnchips", "poke"},
+}
+
+var avoidWords = map[string]string{
+	"牛":       "beef",
+	"beef":    "beef",
+	"豬":       "pork",
+	"pork":    "pork",
+	"海鮮":      "seafood",
+	"seafood": "seafood",
+}
+
+var avoidLabels = map[string]string{"beef": "牛", "pork": "豬", "seafood": "海鮮"}
+
+var (
+	setPreferencePattern = regexp.MustCompile(`(?i)^\s*(?:設定|set\s)\s*(.*)$`)
+	vegetarianPattern    = regexp.MustCompile(`(?i)素食|吃素|vegetarian|vegan`)
+	halalPattern         = regexp.MustCompile(`(?i)清真|halal`)
+	avoidPattern         = regexp.MustCompile(`(?i)(?:不吃|no\s+)(牛|豬|海鮮|beef|pork|seafood)`)
+	pricePattern         = regexp.MustCompile(`(?i)(?:預算|max\s+price)\s*(\$+|[1-4])`)
+	likePattern          = regexp.MustCompile(`(?i)(?:喜歡|like)\s*(.+)`)
+)
+
+// preferences is what a user always wants from a search.
+type preferences struct {
+	Vegetarian bool     `json:"vegetarian,omitempty"`
+	Halal      bool     `json:"halal,omitempty"`
+	Avoid      []string `json:"avoid,omitempty"`
+	// MaxPrice is the most dollar signs the user will pay for. Places
+	// without a price pass allows, though a provider that filters by price
+	// may already have left them out.
+	MaxPrice   int      `json:"max_price,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+func (p preferences) empty() bool {
+	return !p.Vegetarian && !p.Halal && len(p.Avoid) == 0 && p.MaxPrice == 0 && len(p.Categories) == 0
+}
+
+// apply narrows q to p. A category the user asked for explicitly wins over
+// the profile, while the lower of the two budgets wins; allows then drops
+// whatever does not fit the diet.
+func (p preferences) apply(q *PlaceQuery) {
+	if p.MaxPrice > 0 && (q.MaxPrice == 0 || p.MaxPrice < q.MaxPrice) {
+		q.MaxPrice = p.MaxPrice
+	}
+	if len(q.Categories) > 0 {
+		return
+	}
+	var required []string
+	if p.Vegetarian {
+		required = append(required, vegetarianCategories...)
+	}
+	if p.Halal {
+		required = append(required, halalCategories...)
+	}
+	switch {
+	case len(required) > 0:
+		q.Categories = required
+	case len(p.Categories) > 0 && q.Term == "":
+		q.Categories = append([]string(nil), p.Categories...)
+	}
+}
+
+// allows reports whether b fits the diet and budget in p.
+func (p preferences) allows(b Place) bool {
+	if p.MaxPrice > 0 && b.Price > p.MaxPrice {
+		return false
+	}
+	categories := b.CategoryAliases()
+	if p.Vegetarian && !containsAny(categories, vegetarianCategories) {
+		return false
+	}
+	if p.Halal && !containsAny(categories, halalCategories) {
+		return false
+	}
+	for _, food := range p.Avoid {
+		if containsAny(categories, avoidCategories[food]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p preferences) String() string {
+	var lines []string
+	if p.Vegetarian {
+		lines = append(lines, "素食")
+	}
+	if p.Halal {
+		lines = append(lines, "清真")
+	}
+	if len(p.Avoid) > 0 {
+		var foods []string
+		for _, food := range p.Avoid {
+			foods = append(foods, avoidLabels[food])
+		}
+		lines = append(lines, "不吃："+strings.Join(foods, "、"))
+	}
+	if p.MaxPrice > 0 {
+		lines = append(lines, "預算："+strings.Repeat("$", p.MaxPrice))
+	}
+	if len(p.Categories) > 0 {
+		lines = append(lines, "喜歡："+strings.Join(p.Categories, "、"))
+	}
+	return "你的偏好：\n" + strings.Join(lines, "\n")
+}
+
+func containsAny(list, wanted []string) bool {
+	for _, s := range wanted {
+		if containsString(list, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePreferences applies the settings in text to p and reports whether it
+// understood any.
+func parsePreferences(text string, p *preferences) bool {
+	changed := false
+	if vegetarianPattern.MatchString(text) {
+		p.Vegetarian = true
+		changed = true
+	}
+	if halalPattern.MatchString(text) {
+		p.Halal = true
+		changed = true
+	}
+	for _, m := range avoidPattern.FindAllStringSubmatch(text, -1) {
+		food := avoidWords[strings.ToLower(m[1])]
+		if !containsString(p.Avoid, food) {
+			p.Avoid = append(p.Avoid, food)
+			sort.Strings(p.Avoid)
+		}
+		changed = true
+	}
+	if m := pricePattern.FindStringSubmatch(text); m != nil {
+		if m[1][0] == '$' {
+			p.MaxPrice = len(m[1])
+		} else {
+			p.MaxPrice = int(m[1][0] - '0')
+		}
+		if p.MaxPrice > 4 {
+			p.MaxPrice = 4
+		}
+		changed = true
+	}
+	if m := likePattern.FindStringSubmatch(text); m != nil {
+		for _, alias := range parseQuery(m[1]).Categories {
+			if !containsString(p.Categories, alias) {
+				p.Categories = append(p.Categories, alias)
+			}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// preferencesCommand shows, sets and clears the user's preferences.
+func preferencesCommand(c *dialogContext, text string) (DialogState, bool) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "偏好", "我的偏好", "preferences", "prefs":
+		if p := profiles.Get(c.User); !p.empty() {
+			c.Reply.Text(p.String())
+		} else {
+			c.Reply.Text(promptNoPreferences)
+		}
+		return "", true
+	case "清除偏好", "clear preferences":
+		profiles.Clear(c.User)
+		c.Reply.Text(promptClearedPrefs)
+		return "", true
+	}
+
+	m := setPreferencePattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", false
+	}
+	p := profiles.Get(c.User)
+	if !parsePreferences(m[1], &p) {
+		c.Reply.Text(promptPreferencesHelp)
+		return "", true
+	}
+	profiles.Set(c.User, p)
+	c.Reply.Text(p.String())
+	return "", true
+}
+
+type preferencesRecord struct {
+	User        string       `json:"user"`
+	Preferences *preferences `json:"preferences,omitempty"`
+}
+
+// preferenceStore keeps every user's preferences.
+type preferenceStore struct {
+	mu      sync.Mutex
+	users   map[string]preferences
+	journal *journal
+}
+
+func newPreferenceStore() *preferenceStore {
+	return &preferenceStore{users: make(map[string]preferences)}
+}
+
+// openPreferenceStore returns a preferenceStore journaled to path.
+func openPreferenceStore(path string) (*preferenceStore, error) {
+	s := newPreferenceStore()
+	j, err := openJournal(path, func(line []byte) error {
+		var r preferencesRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if r.Preferences == nil {
+			delete(s.users, r.User)
+		} else {
+			s.users[r.User] = *r.Preferences
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := j.Compact(s.live()); err != nil {
+		j.Close()
+		return nil, err
+	}
+	s.journal = j
+	return s, nil
+}
+
+// Get returns user's preferences, empty if they never set any.
+func (s *preferenceStore) Get(user string) preferences {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[user]
+}
+
+func (s *preferenceStore) Set(user string, p preferences) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user] = p
+	s.append(preferencesRecord{User: user, Preferences: &p})
+}
+
+func (s *preferenceStore) Clear(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user]; !ok {
+		return
+	}
+	delete(s.users, user)
+	s.append(preferencesRecord{User: user})
+}
+
+// live returns every user's preferences as a record. Callers hold s.mu, or
+// own s exclusively.
+func (s *preferenceStore) live() []interface{} {
+	var live []interface{}
+	for user, p := range s.users {
+		p := p
+		live = append(live, preferencesRecord{User: user, Preferences: &p})
+	}
+	return live
+}
+
+// append journals r. Callers hold s.mu.
+func (s *preferenceStore) append(r preferencesRecord) {
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.Append(r); err != nil {
+		log.Println(err)
+	}
+	if err := s.journal.CompactIfDue(s.live); err != nil {
+		log.Println(err)
+	}
+}
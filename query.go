@@ -0,0 +1,286 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/guregu/null"
+)
+
+// sort modes, numbered the way Yelp v2 does
+const (
+	sortBestMatched = 0
+	sortDistance    = 1
+	sortHighestRate = 2
+)
+
+// maxRadius is the largest radius_filter Yelp accepts, in meters.
+const maxRadius = 40000
+
+// walkingRadius is what "walking distance" means, in meters.
+const walkingRadius = 800
+
+// cheapPrice is what "cheap" means, in Yelp's dollar signs.
+const cheapPrice = 2
+
+// searchQuery is what a user's free text asks for once the filters have been
+// pulled out of it.
+type searchQuery struct {
+	Term       string
+	Radius     null.Float
+	Sort       null.Int
+	Deals      null.Bool
+	MaxPrice   int
+	OpenNow    bool
+	Categories []string
+}
+
+var (
+	distancePattern = regexp.MustCompile(`(?i)(?:within\s+|在)?(\d+(?:\.\d+)?)\s*(公里|千米|公尺|米|(?:km|meters?|miles?|mi|m)\b)\s*(?:範圍內|以內|之內|內)?`)
+	walkingPattern  = regexp.MustCompile(`(?i)走路可到|走路就到|步行可到|步行|走路|(?:within\s+)?walking\s+distance`)
+	ratingPattern   = regexp.MustCompile(`(?i)評價最高|評價高的?|評分高的?|高評價|好評|(?:top|best|highest)[\s-]+rated`)
+	nearestPattern  = regexp.MustCompile(`(?i)離我最近的?|附近的?|(?:closest|nearest|nearby|near\s+me)\b`)
+	dealsPattern    = regexp.MustCompile(`(?i)有優惠的?|優惠|折扣|(?:with\s+)?(?:deals?|coupons?|discounts?)\b`)
+	cheapPattern    = regexp.MustCompile(`(?i)便宜的?|平價的?|(?:cheap|inexpensive|affordable)\b`)
+	openNowPattern  = regexp.MustCompile(`(?i)現在有開的?|現在營業的?|營業中的?|(?:open\s+now)\b`)
+	// 最近 also means "lately", so it only asks for the nearest when it
+	// stands alone or is followed by 的 or a food
+	recentPattern = regexp.MustCompile(`最近(的)?`)
+	punctPattern  = regexp.MustCompile(`[，,、。!！?？]+`)
+)
+
+// categoryWords maps what users type to Yelp category aliases.
+var categoryWords = map[string]string{
+	"拉麵":         "ramen",
+	"ramen":      "ramen",
+	"壽司":         "sushi",
+	"sushi":      "sushi",
+	"披薩":         "pizza",
+	"pizza":      "pizza",
+	"漢堡":         "burgers",
+	"burger":     "burgers",
+	"burgers":    "burgers",
+	"火鍋":         "hotpot",
+	"hotpot":     "hotpot",
+	"hot pot":    "hotpot",
+	"牛排":         "steak",
+	"steak":      "steak",
+	"燒肉":         "bbq",
+	"bbq":        "bbq",
+	"咖啡":         "coffee",
+	"coffee":     "coffee",
+	"咖啡廳":        "cafes",
+	"cafe":       "cafes",
+	"甜點":         "desserts",
+	"dessert":    "desserts",
+	"desserts":   "desserts",
+	"早午餐":        "breakfast_brunch",
+	"早餐":         "breakfast_brunch",
+	"brunch":     "breakfast_brunch",
+	"breakfast":  "breakfast_brunch",
+	"素食":         "vegetarian",
+	"vegetarian": "vegetarian",
+	"vegan":      "vegan",
+	"日本料理":       "japanese",
+	"日式":         "japanese",
+	"japanese":   "japanese",
+	"韓式":         "korean",
+	"韓國料理":       "korean",
+	"korean":     "korean",
+	"泰式":         "thai",
+	"thai":       "thai",
+	"義式":         "italian",
+	"italian":    "italian",
+	"小吃":         "taiwanese",
+	"台菜":         "taiwanese",
+	"taiwanese":  "taiwanese",
+	"海鮮":         "seafood",
+	"seafood":    "seafood",
+}
+
+// categoryKeys lists categoryWords longest first, so "咖啡廳" wins over "咖啡".
+var categoryKeys = func() []string {
+	keys := make([]string, 0, len(categoryWords))
+	for k := range categoryWords {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}()
+
+// parseQuery pulls distance, sort, deals, price, open now and category
+// filters out of text, in Traditional Chinese or English, and keeps the rest
+// as the search term.
+func parseQuery(text string) searchQuery {
+	var q searchQuery
+	rest := " " + text + " "
+
+	if m := distancePattern.FindStringSubmatch(rest); m != nil {
+		if meters, ok := toMeters(m[1], m[2]); ok {
+			q.Radius = null.FloatFrom(meters)
+			rest = strings.Replace(rest, m[0], " ", 1)
+		}
+	}
+	if loc := walkingPattern.FindStringIndex(rest); loc != nil {
+		if !q.Radius.Valid {
+			q.Radius = null.FloatFrom(walkingRadius)
+		}
+		rest = rest[:loc[0]] + " " + rest[loc[1]:]
+	}
+	if loc := ratingPattern.FindStringIndex(rest); loc != nil {
+		q.Sort = null.IntFrom(sortHighestRate)
+		rest = rest[:loc[0]] + " " + rest[loc[1]:]
+	} else if loc := nearestPattern.FindStringIndex(rest); loc != nil {
+		q.Sort = null.IntFrom(sortDistance)
+		rest = rest[:loc[0]] + " " + rest[loc[1]:]
+	} else if loc := indexNearest(rest); loc != nil {
+		q.Sort = null.IntFrom(sortDistance)
+		rest = rest[:loc[0]] + " " + rest[loc[1]:]
+	}
+	if loc := dealsPattern.FindStringIndex(rest); loc != nil {
+		q.Deals = null.BoolFrom(true)
+		rest = rest[:loc[0]] + " " + rest[loc[1]:]
+	}
+	if loc := cheapPattern.FindStringIndex(rest); loc != nil {
+		q.MaxPrice = cheapPrice
+		rest = rest[:loc[0]] + " " + rest[loc[1]:]
+	}
+	if loc := openNowPattern.FindStringIndex(rest); loc != nil {
+		q.OpenNow = true
+		rest = rest[:loc[0]] + " " + rest[loc[1]:]
+	}
+
+	lower := strings.Map(asciiLower, rest)
+	for _, word := range categoryKeys {
+		i := indexWord(lower, word)
+		if i < 0 {
+			continue
+		}
+		alias := categoryWords[word]
+		if !containsString(q.Categories, alias) {
+			q.Categories = append(q.Categories, alias)
+		}
+		rest = rest[:i] + " " + rest[i+len(word):]
+		lower = lower[:i] + " " + lower[i+len(word):]
+	}
+
+	fields := strings.Fields(punctPattern.ReplaceAllString(rest, " "))
+	for len(fields) > 0 && isFiller(fields[0]) {
+		fields = fields[1:]
+	}
+	for len(fields) > 0 && isFiller(fields[len(fields)-1]) {
+		fields = fields[:len(fields)-1]
+	}
+	q.Term = strings.Join(fields, " ")
+	return q
+}
+
+// indexNearest finds 最近 where it means the nearest: on its own, before 的,
+// or right before a category word.
+func indexNearest(s string) []int {
+	for _, m := range recentPattern.FindAllStringSubmatchIndex(s, -1) {
+		after := s[m[1]:]
+		punct := punctPattern.FindStringIndex(after)
+		alone := strings.TrimSpace(after) == "" || strings.HasPrefix(after, " ") || punct != nil && punct[0] == 0
+		if alone || m[2] >= 0 || startsWithCategory(after) {
+			return m[:2]
+		}
+	}
+	return nil
+}
+
+func startsWithCategory(s string) bool {
+	lower := strings.Map(asciiLower, s)
+	for _, word := range categoryKeys {
+		if strings.HasPrefix(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFiller(word string) bool {
+	switch strings.ToLower(word) {
+	case "with", "and", "in", "at", "的":
+		return true
+	}
+	return false
+}
+
+// indexWord finds word in s. ASCII words must stand alone so that "thai"
+// does not match inside "thailand"; Chinese words may appear anywhere.
+func indexWord(s, word string) int {
+	if word[0] >= 0x80 {
+		return strings.Index(s, word)
+	}
+	for from := 0; ; {
+		i := strings.Index(s[from:], word)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		end := i + len(word)
+		if (i == 0 || !isASCIILetter(s[i-1])) && (end == len(s) || !isASCIILetter(s[end])) {
+			return i
+		}
+		from = i + 1
+	}
+}
+
+// asciiLower lower-cases ASCII letters only, so byte offsets stay the same.
+func asciiLower(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func toMeters(amount, unit string) (float64, bool) {
+	n, err := strconv.ParseFloat(amount, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	switch strings.ToLower(unit) {
+	case "公里", "千米", "km":
+		n *= 1000
+	case "mi", "mile", "miles":
+		n *= 1609.344
+	}
+	if n > maxRadius {
+		n = maxRadius
+	}
+	return n, true
+}
+
+// PlaceQuery returns q as a search for a PlaceProvider.
+func (q searchQuery) PlaceQuery() PlaceQuery {
+	return PlaceQuery{
+		Term:       q.Term,
+		Categories: q.Categories,
+		Radius:     q.Radius,
+		Sort:       q.Sort,
+		Deals:      q.Deals,
+		MaxPrice:   q.MaxPrice,
+		OpenNow:    q.OpenNow,
+	}
+}
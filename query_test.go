@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guregu/null"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want searchQuery
+	}{
+		{"牛肉麵", searchQuery{Term: "牛肉麵"}},
+		{"500公尺內的拉麵", searchQuery{Radius: null.FloatFrom(500), Categories: []string{"ramen"}}},
+		{"ramen within 2 km", searchQuery{Radius: null.FloatFrom(2000), Categories: []string{"ramen"}}},
+		{"走路可到 評價最高的壽司", searchQuery{Radius: null.FloatFrom(walkingRadius), Sort: null.IntFrom(sortHighestRate), Categories: []string{"sushi"}}},
+		{"附近有優惠的咖啡廳", searchQuery{Sort: null.IntFrom(sortDistance), Deals: null.BoolFrom(true), Categories: []string{"cafes"}}},
+		{"nearest thai food", searchQuery{Term: "food", Sort: null.IntFrom(sortDistance), Categories: []string{"thai"}}},
+		{"thailand noodles", searchQuery{Term: "thailand noodles"}},
+		{"100 km 火鍋", searchQuery{Radius: null.FloatFrom(maxRadius), Categories: []string{"hotpot"}}},
+		{"拉麵 500公尺內 評價高", searchQuery{Radius: null.FloatFrom(500), Sort: null.IntFrom(sortHighestRate), Categories: []string{"ramen"}}},
+		{"cheap pizza with deals", searchQuery{Deals: null.BoolFrom(true), MaxPrice: cheapPrice, Categories: []string{"pizza"}}},
+		{"open now sushi", searchQuery{OpenNow: true, Categories: []string{"sushi"}}},
+		{"便宜的拉麵", searchQuery{MaxPrice: cheapPrice, Categories: []string{"ramen"}}},
+		{"平價牛肉麵", searchQuery{Term: "牛肉麵", MaxPrice: cheapPrice}},
+		{"現在有開的火鍋", searchQuery{OpenNow: true, Categories: []string{"hotpot"}}},
+		{"營業中 便宜 咖啡廳", searchQuery{MaxPrice: cheapPrice, OpenNow: true, Categories: []string{"cafes"}}},
+		{"最近的牛肉麵", searchQuery{Term: "牛肉麵", Sort: null.IntFrom(sortDistance)}},
+		{"最近 拉麵", searchQuery{Sort: null.IntFrom(sortDistance), Categories: []string{"ramen"}}},
+		{"最近拉麵", searchQuery{Sort: null.IntFrom(sortDistance), Categories: []string{"ramen"}}},
+		{"最近好嗎", searchQuery{Term: "最近好嗎"}},
+		{"open late diner", searchQuery{Term: "open late diner"}},
+	}
+	for _, tt := range tests {
+		if got := parseQuery(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseQuery(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// seenWindow is how long a recommendation keeps pushing the same business
+// down for that user.
+const seenWindow = 7 * 24 * time.Hour
+
+const (
+	promptFeedback = "喜歡嗎？回覆 👍1 或 👎2 告訴我第幾家合不合你的胃口"
+	promptLiked    = "收到！之後會多推薦你喜歡的類型"
+	promptRejected = "收到！之後會少推薦這家"
+	promptNoTurn   = "還沒有推薦過餐廳給你喔！\n\n" + promptFood
+)
+
+var feedbackPattern = regexp.MustCompile(`(?i)^\s*(👍|👎|讚|爛|like|dislike)\s*([1-9])?\s*$`)
+
+// feedbackCommand records 👍/👎 replies about the last recommendations. A
+// number picks one of them; without it the vote counts for all of them.
+func feedbackCommand(c *dialogContext, text string) (DialogState, bool) {
+	m := feedbackPattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", false
+	}
+	vote := 1
+	switch strings.ToLower(m[1]) {
+	case "👎", "爛", "dislike":
+		vote = -1
+	}
+
+	ids := history.LastTurn(c.User)
+	if m[2] != "" {
+		n, _ := strconv.Atoi(m[2])
+		if n > len(ids) {
+			ids = nil
+		} else {
+			ids = ids[n-1 : n]
+		}
+	}
+	if len(ids) == 0 {
+		c.Reply.Prompt(promptNoTurn)
+		return "", true
+	}
+	for _, id := range ids {
+		history.Feedback(c.User, id, vote)
+	}
+	if vote > 0 {
+		c.Reply.Text(promptLiked)
+	} else {
+		c.Reply.Text(promptRejected)
+	}
+	return "", true
+}
+
+type rankedBusiness struct {
+	business Place
+	score    float64
+	rejected bool
+}
+
+// personalize reorders businesses for user from their history: places they
+// rejected or saw recently sink, categories they liked rise. It returns the
+// better half, at least three, for the recommender to draw from. Rejected
+// places are left out unless there is nothing else.
+func personalize(user string, businesses []Place) []Place {
+	entries := history.Entries(user)
+	if len(entries) == 0 || len(businesses) == 0 {
+		return businesses
+	}
+
+	now := time.Now()
+	rejected := make(map[string]bool)
+	lastSeen := make(map[string]time.Time)
+	categoryVotes := make(map[string]int)
+	for _, e := range entries {
+		lastSeen[e.Business] = e.At
+		// a 👎 sticks through later showings until a 👍 takes it back
+		switch {
+		case e.Feedback < 0:
+			rejected[e.Business] = true
+		case e.Feedback > 0:
+			rejected[e.Business] = false
+		}
+		for _, c := range e.Categories {
+			categoryVotes[c] += e.Feedback
+		}
+	}
+
+	ranked := make([]rankedBusiness, len(businesses))
+	for i, b := range businesses {
+		// the provider's own order is the starting point
+		score := 1 - float64(i)/float64(len(businesses))
+		if at, ok := lastSeen[b.ID]; ok {
+			if age := now.Sub(at); age < seenWindow {
+				score -= 1 - float64(age)/float64(seenWindow)
+			}
+		}
+		for _, c := range b.CategoryAliases() {
+			votes := categoryVotes[c]
+			if votes > 3 {
+				votes = 3
+			} else if votes < -3 {
+				votes = -3
+			}
+			score += 0.3 * float64(votes)
+		}
+		ranked[i] = rankedBusiness{business: b, score: score, rejected: rejected[b.ID]}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].rejected != ranked[j].rejected {
+			return !ranked[i].rejected
+		}
+		return ranked[i].score > ranked[j].score
+	})
+
+	keep := 0
+	for keep < len(ranked) && !ranked[keep].rejected {
+		keep++
+	}
+	if keep == 0 {
+		keep = len(ranked)
+	}
+	if half := (keep + 1) / 2; half >= 3 {
+		keep = half
+	} else if keep > 3 {
+		keep = 3
+	}
+	pool := make([]Place, keep)
+	for i := range pool {
+		pool[i] = ranked[i].business
+	}
+	return pool
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// rankTestPlaces are two ramen shops and four pizzerias, in the provider's
+// order.
+func rankTestPlaces() []Place {
+	ramen := []PlaceCategory{{Alias: "ramen"}}
+	pizza := []PlaceCategory{{Alias: "pizza"}}
+	return []Place{
+		{ID: "a", Categories: ramen},
+		{ID: "b", Categories: ramen},
+		{ID: "c", Categories: pizza},
+		{ID: "d", Categories: pizza},
+		{ID: "e", Categories: pizza},
+		{ID: "f", Categories: pizza},
+	}
+}
+
+func TestPersonalize(t *testing.T) {
+	saved := history
+	defer func() { history = saved }()
+	history = newHistoryStore()
+	places := rankTestPlaces()
+
+	if got := personalize("nobody", places); !reflect.DeepEqual(placeIDs(got), []string{"a", "b", "c", "d", "e", "f"}) {
+		t.Errorf("without history: %v", placeIDs(got))
+	}
+
+	// what was just recommended sinks
+	history.Recommended("seen", places[:1])
+	if got := placeIDs(personalize("seen", places)); !reflect.DeepEqual(got, []string{"b", "c", "d"}) {
+		t.Errorf("after seeing a: %v", got)
+	}
+
+	// liked categories rise, even for places never shown
+	monthAgo := time.Now().Add(-30 * 24 * time.Hour)
+	for _, id := range []string{"x", "y", "z"} {
+		history.users["liker"] = append(history.users["liker"], historyEntry{
+			Business: id, Categories: []string{"pizza"}, At: monthAgo, Feedback: 1,
+		})
+	}
+	if got := placeIDs(personalize("liker", places)); !reflect.DeepEqual(got, []string{"c", "d", "e"}) {
+		t.Errorf("after liking pizza: %v", got)
+	}
+}
+
+func TestPersonalizeRejected(t *testing.T) {
+	saved := history
+	defer func() { history = saved }()
+	history = newHistoryStore()
+	places := rankTestPlaces()
+
+	history.Recommended("alice", places[:1])
+	history.Feedback("alice", "a", -1)
+	// a 👎 sticks when the place is shown again without feedback
+	history.Recommended("alice", places[:1])
+	for _, id := range placeIDs(personalize("alice", places)) {
+		if id == "a" {
+			t.Errorf("rejected a is back in %v", placeIDs(personalize("alice", places)))
+		}
+	}
+	// unless there is nothing else
+	if got := placeIDs(personalize("alice", places[:1])); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("only a rejected place: %v", got)
+	}
+
+	// a 👍 takes it back
+	history.Feedback("alice", "a", 1)
+	found := false
+	for _, p := range personalize("alice", places[:2]) {
+		found = found || p.ID == "a"
+	}
+	if !found {
+		t.Error("a still rejected after a 👍")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+// maxMessagesPerSend is the most messages one multiple-message request may
+// carry; longer replies are split into several requests.
+const maxMessagesPerSend = 5
+
+type replyMessage struct {
+	contentType linebot.ContentType
+	text        string
+	imageURL    string
+	previewURL  string
+	title       string
+	address     string
+	latitude    float64
+	longitude   float64
+	rich        *linebot.RichMessageRequest
+}
+
+// reply collects every message of one bot turn so that it can go out as a
+// single ordered multiple-message request.
+type reply struct {
+	to       string
+	messages []replyMessage
+}
+
+func newReply(to string) *reply {
+	return &reply{to: to}
+}
+
+// Text adds a text message.
+func (r *reply) Text(text string) *reply {
+	r.messages = append(r.messages, replyMessage{
+		contentType: linebot.ContentTypeText,
+		text:        text,
+	})
+	return r
+}
+
+// Image adds an image message.
+func (r *reply) Image(imageURL, previewURL string) *reply {
+	r.messages = append(r.messages, replyMessage{
+		contentType: linebot.ContentTypeImage,
+		imageURL:    imageURL,
+		previewURL:  previewURL,
+	})
+	return r
+}
+
+// Location adds a location message.
+func (r *reply) Location(title, address string, latitude, longitude float64) *reply {
+	r.messages = append(r.messages, replyMessage{
+		contentType: linebot.ContentTypeLocation,
+		title:       title,
+		address:     address,
+		latitude:    latitude,
+		longitude:   longitude,
+	})
+	return r
+}
+
+// Rich adds a rich message. It cannot be part of a multiple-message request,
+// so it is sent on its own between the messages around it.
+func (r *reply) Rich(rmr *linebot.RichMessageRequest, imageURL, altText string) *reply {
+	r.messages = append(r.messages, replyMessage{
+		contentType: linebot.ContentTypeRichMessage,
+		imageURL:    imageURL,
+		text:        altText,
+		rich:        rmr,
+	})
+	return r
+}
+
+// Prompt adds text followed by the prompt illustration.
+func (r *reply) Prompt(text string) *reply {
+	return r.Text(text).Image(promptImage, promptImage)
+}
+
+// Send delivers the collected messages in order and empties the reply.
+// A single message goes out as a plain send. Nothing goes to a user who
+// blocked the bot.
+func (r *reply) Send() error {
+	messages := r.messages
+	r.messages = nil
+	if blocks.Blocked(r.to) {
+		return nil
+	}
+	for len(messages) > 0 {
+		if messages[0].rich != nil {
+			m := messages[0]
+			if _, err := m.rich.Send([]string{r.to}, m.imageURL, m.text); err != nil {
+				return err
+			}
+			messages = messages[1:]
+			continue
+		}
+		n := 0
+		for n < len(messages) && n < maxMessagesPerSend && messages[n].rich == nil {
+			n++
+		}
+		if err := r.send(messages[:n]); err != nil {
+			return err
+		}
+		messages = messages[n:]
+	}
+	return nil
+}
+
+func (r *reply) send(messages []replyMessage) error {
+	to := []string{r.to}
+	if len(messages) == 1 {
+		m := messages[0]
+		var err error
+		switch m.contentType {
+		case linebot.ContentTypeText:
+			_, err = bot.SendText(to, m.text)
+		case linebot.ContentTypeImage:
+			_, err = bot.SendImage(to, m.imageURL, m.previewURL)
+		case linebot.ContentTypeLocation:
+			_, err = bot.SendLocation(to, m.title, m.address, m.latitude, m.longitude)
+		}
+		return err
+	}
+
+	mmr := bot.NewMultipleMessage()
+	for _, m := range messages {
+		switch m.contentType {
+		case linebot.ContentTypeText:
+			mmr.AddText(m.text)
+		case linebot.ContentTypeImage:
+			mmr.AddImage(m.imageURL, m.previewURL)
+		case linebot.ContentTypeLocation:
+			mmr.AddLocation(m.title, m.address, m.latitude, m.longitude)
+		default:
+			log.Printf("reply: cannot send content type %d", m.contentType)
+		}
+	}
+	_, err := mmr.Send(to)
+	return err
+}
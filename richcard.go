@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// businessCache remembers recently recommended businesses so the HTTP
+// endpoints behind a card can find them by ID without another search.
+type businessCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string]businessCacheEntry
+}
+
+type businessCacheEntry struct {
+	business Place
+	added    time.Time
+}
+
+func newBusinessCache(ttl time.Duration) *businessCache {
+	return &businessCache{ttl: ttl, items: make(map[string]businessCacheEntry)}
+}
+
+// Add remembers b.
+func (c *businessCache) Add(b Place) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[b.ID] = businessCacheEntry{business: b, added: now}
+	for id, e := range c.items {
+		if now.Sub(e.added) > c.ttl {
+			delete(c.items, id)
+		}
+	}
+}
+
+// Get returns the business with id if it is still remembered.
+func (c *businessCache) Get(id string) (Place, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[id]
+	if !ok || time.Since(e.added) > c.ttl {
+		return Place{}, false
+	}
+	return e.business, true
+}
+
+var recentBusinesses = newBusinessCache(24 * time.Hour)
+
+// signAction returns a token proving a button for business came from a card
+// the bot sent user, so the link cannot be reused for another business.
+func signAction(user, business string) string {
+	return hex.EncodeToString(actionMAC(user, business))
+}
+
+func verifyAction(user, business, sig string) bool {
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(want, actionMAC(user, business))
+}
+
+func actionMAC(user, business string) []byte {
+	mac := hmac.New(sha256.New, actionSecret)
+	mac.Write([]byte(user))
+	mac.Write([]byte{0})
+	mac.Write([]byte(business))
+	return mac.Sum(nil)
+}
+
+// actionParams reads and checks the parameters of a card button. Buttons
+// are plain links that link previews may open too, so claimAction makes
+// each one act once.
+func actionParams(r *http.Request) (user, business string, ok bool) {
+	q := r.URL.Query()
+	user, business = q.Get("u"), q.Get("b")
+	if user == "" || business == "" || !verifyAction(user, business, q.Get("sig")) || blocks.Blocked(user) {
+		return "", "", false
+	}
+	return user, business, true
+}
+
+// claimAction reports whether the button action for user and business has
+// not run within the dedup TTL.
+func claimAction(action, user, business string) bool {
+	return dedup.Claim(action + ":" + user + ":" + business)
+}
+
+// releaseAction lets the button action run again.
+func releaseAction(action, user, business string) {
+	dedup.Release(action + ":" + user + ":" + business)
+}
+
+// directionsURL opens map directions to b.
+func directionsURL(b Place) string {
+	return fmt.Sprintf("https://www.google.com/maps/dir/?api=1&destination=%v,%v", b.Latitude, b.Longitude)
+}
+
+// addRichCard adds b to r as a tappable card: the photo opens its page,
+// the buttons below map directions, saving it and more places like it.
+func addRichCard(r *reply, user string, b Place, link string) {
+	if link == "" {
+		link = b.URL
+	}
+	params := url.Values{
+		"b":   {b.ID},
+		"u":   {user},
+		"sig": {signAction(user, b.ID)},
+	}.Encode()
+
+	rmr := bot.NewRichMessage(richCanvasSize).
+		SetAction("page", b.Name, link).
+		SetListener("page", 0, 0, richCanvasSize, richButtonsTop).
+		SetAction("map", "地圖", directionsURL(b)).
+		SetListener("map", 0, richButtonsTop, richButtonWidth, richButtonHeight).
+		SetAction("save", "收藏", publicURL+"/save?"+params).
+		SetListener("save", richButtonWidth, richButtonsTop, richButtonWidth, richButtonHeight).
+		SetAction("more", "更多類似的", publicURL+"/more?"+params).
+		SetListener("more", 2*richButtonWidth, richButtonsTop, richCanvasSize-2*richButtonWidth, richButtonHeight)
+	r.Rich(rmr, publicURL+"/rich/"+url.PathEscape(b.ID), b.Name)
+}
+
+// renderRichImage draws the rich message canvas for b.
+func renderRichImage(b Place, photo image.Image) *image.RGBA {
+	return composeRichImage(photo, cardTitle(b), b.Rating)
+}
+
+// moreHandler answers the "more" button of a card by sending the user other
+// places of the same kind near that business. The search runs on the user's
+// worker, in order with their messages.
+func moreHandler(w http.ResponseWriter, r *http.Request) {
+	user, id, ok := actionParams(r)
+	if !ok {
+		w.WriteHeader(403)
+		return
+	}
+	b, ok := recentBusinesses.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if claimAction("more", user, id) {
+		err := events.EnqueueFunc(user, "more:"+id, func() {
+			if !blocks.Blocked(user) {
+				sendMoreLike(user, b)
+			}
+		})
+		if err != nil {
+			log.Println(err)
+			releaseAction("more", user, id)
+			w.WriteHeader(503)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	fmt.Fprint(w, "<p>已傳送更多類似的餐廳，請回到 LINE 查看。</p>")
+}
+
+func sendMoreLike(user string, b Place) {
+	var q PlaceQuery
+	if len(b.Categories) > 0 && b.Categories[0].Alias != "" {
+		q.Categories = []string{b.Categories[0].Alias}
+	}
+	prefs := profiles.Get(user)
+	prefs.apply(&q)
+
+	results, err := places.SearchByCoordinate(q, b.Latitude, b.Longitude)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	others := results.Places[:0]
+	for _, other := range results.Places {
+		if other.ID != b.ID && prefs.allows(other) {
+			others = append(others, other)
+		}
+	}
+	results.Places = others
+
+	c := &dialogContext{User: user, Places: places, Reply: newReply(user)}
+	showResults(c, results)
+	if err := c.Reply.Send(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestSignAction(t *testing.T) {
+	actionSecret = []byte("secret")
+	sig := signAction("alice", "pho-house")
+	if !verifyAction("alice", "pho-house", sig) {
+		t.Error("signature rejected")
+	}
+	if verifyAction("alice", "ramen-bar", sig) {
+		t.Error("signature accepted for another business")
+	}
+	if verifyAction("bob", "pho-house", sig) {
+		t.Error("signature accepted for another user")
+	}
+	if verifyAction("alic", "epho-house", sig) {
+		t.Error("signature accepted for a shifted split")
+	}
+	if verifyAction("alice", "pho-house", "zz") {
+		t.Error("malformed signature accepted")
+	}
+}
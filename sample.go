@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// sampleStrategy decides which of the returned businesses get recommended.
+type sampleStrategy string
+
+// sampleStrategy constants
+const (
+	sampleTopN     sampleStrategy = "top"
+	sampleUniform  sampleStrategy = "random"
+	sampleWeighted sampleStrategy = "weighted"
+)
+
+// sampler draws distinct businesses from a search result. The same seed
+// gives the same draws, which keeps tests reproducible.
+type sampler struct {
+	strategy sampleStrategy
+	mu       sync.Mutex
+	rng      *rand.Rand
+}
+
+func newSampler(strategy sampleStrategy, seed int64) (*sampler, error) {
+	switch strategy {
+	case sampleTopN, sampleUniform, sampleWeighted:
+	case "":
+		strategy = sampleUniform
+	default:
+		return nil, fmt.Errorf("unknown sample strategy %q", strategy)
+	}
+	return &sampler{strategy: strategy, rng: rand.New(rand.NewSource(seed))}, nil
+}
+
+// Sample returns up to n different businesses out of businesses, which may
+// hold fewer than n. Businesses listed twice are only drawn once.
+func (s *sampler) Sample(businesses []Place, n int) []Place {
+	pool := distinctBusinesses(businesses)
+	if n > len(pool) {
+		n = len(pool)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	switch s.strategy {
+	case sampleTopN:
+		return pool[:n]
+	case sampleWeighted:
+		return s.weighted(pool, n)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	picked := make([]Place, n)
+	for i, j := range s.rng.Perm(len(pool))[:n] {
+		picked[i] = pool[j]
+	}
+	return picked
+}
+
+// weighted draws without replacement, favouring well rated businesses with
+// many reviews.
+func (s *sampler) weighted(pool []Place, n int) []Place {
+	weights := make([]float64, len(pool))
+	total := 0.0
+	for i, b := range pool {
+		weights[i] = businessWeight(b)
+		total += weights[i]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	picked := make([]Place, 0, n)
+	for len(picked) < n {
+		r := s.rng.Float64() * total
+		i := -1
+		for j, w := range weights {
+			if w == 0 {
+				continue
+			}
+			// rounding may leave r past the end, so default to the last one
+			i = j
+			if r < w {
+				break
+			}
+			r -= w
+		}
+		picked = append(picked, pool[i])
+		total -= weights[i]
+		weights[i] = 0
+	}
+	return picked
+}
+
+// businessWeight grows with the rating and, more slowly, with the number of
+// reviews behind it. Every business keeps some chance of being drawn.
+func businessWeight(b Place) float64 {
+	rating := b.Rating
+	if rating <= 0 {
+		rating = 1
+	}
+	return rating * rating * math.Log(float64(b.ReviewCount)+2)
+}
+
+func distinctBusinesses(businesses []Place) []Place {
+	seen := make(map[string]bool, len(businesses))
+	pool := make([]Place, 0, len(businesses))
+	for _, b := range businesses {
+		if b.ID != "" && seen[b.ID] {
+			continue
+		}
+		seen[b.ID] = true
+		pool = append(pool, b)
+	}
+	return pool
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func samplePool() []Place {
+	return []Place{
+		{ID: "a", Rating: 5, ReviewCount: 500},
+		{ID: "b", Rating: 4, ReviewCount: 50},
+		{ID: "c", Rating: 3, ReviewCount: 5},
+		{ID: "d", Rating: 2},
+		{ID: "e", Rating: 1},
+	}
+}
+
+func placeIDs(places []Place) []string {
+	ids := make([]string, len(places))
+	for i, p := range places {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+func TestSampleDistinct(t *testing.T) {
+	pool := append(samplePool(), samplePool()...)
+	for _, strategy := range []sampleStrategy{sampleTopN, sampleUniform, sampleWeighted} {
+		s, err := newSampler(strategy, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for round := 0; round < 50; round++ {
+			ids := placeIDs(s.Sample(pool, 5))
+			if len(ids) != 5 {
+				t.Fatalf("%s: drew %v", strategy, ids)
+			}
+			seen := make(map[string]bool)
+			for _, id := range ids {
+				if seen[id] {
+					t.Fatalf("%s: %s drawn twice in %v", strategy, id, ids)
+				}
+				seen[id] = true
+			}
+		}
+	}
+}
+
+func TestSampleMoreThanPool(t *testing.T) {
+	for _, strategy := range []sampleStrategy{sampleTopN, sampleUniform, sampleWeighted} {
+		s, _ := newSampler(strategy, 1)
+		if got := s.Sample(samplePool()[:2], 3); len(got) != 2 {
+			t.Errorf("%s: drew %v from two", strategy, placeIDs(got))
+		}
+		if got := s.Sample(nil, 3); len(got) != 0 {
+			t.Errorf("%s: drew %v from none", strategy, placeIDs(got))
+		}
+	}
+}
+
+func TestSampleTopN(t *testing.T) {
+	s, _ := newSampler(sampleTopN, 1)
+	if got := placeIDs(s.Sample(samplePool(), 3)); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("drew %v", got)
+	}
+}
+
+func TestSampleSeedReproducible(t *testing.T) {
+	for _, strategy := range []sampleStrategy{sampleUniform, sampleWeighted} {
+		first, _ := newSampler(strategy, 42)
+		second, _ := newSampler(strategy, 42)
+		for round := 0; round < 10; round++ {
+			a := placeIDs(first.Sample(samplePool(), 3))
+			b := placeIDs(second.Sample(samplePool(), 3))
+			if !reflect.DeepEqual(a, b) {
+				t.Fatalf("%s: same seed drew %v and %v", strategy, a, b)
+			}
+		}
+	}
+}
+
+func TestSampleWeightedFavoursRated(t *testing.T) {
+	s, _ := newSampler(sampleWeighted, 7)
+	first := make(map[string]int)
+	for round := 0; round < 2000; round++ {
+		first[s.Sample(samplePool(), 1)[0].ID]++
+	}
+	if first["a"] <= first["b"] || first["b"] <= first["c"] || first["c"] <= first["e"] {
+		t.Errorf("first draws %v do not follow the weights", first)
+	}
+	if first["e"] == 0 {
+		t.Errorf("the lowest rated place was never drawn: %v", first)
+	}
+}
+
+func TestNewSamplerStrategy(t *testing.T) {
+	if s, err := newSampler("", 1); err != nil || s.strategy != sampleUniform {
+		t.Errorf("empty strategy: %v, %v", s, err)
+	}
+	if _, err := newSampler("best", 1); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+}
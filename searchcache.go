@@ -0,0 +1,258 @@
+package main
+
+import (
+	"container/list"
+	"expvar"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// search cache metrics, published on /debug/vars. Hits and stale hits are
+// the searches the provider was spared.
+var (
+	searchCacheHits      = expvar.NewInt("search_cache_hits")
+	searchCacheStaleHits = expvar.NewInt("search_cache_stale_hits")
+	searchCacheMisses    = expvar.NewInt("search_cache_misses")
+	searchCacheRefreshes = expvar.NewInt("search_cache_refresh_errors")
+	searchCacheEntries   = expvar.NewInt("search_cache_entries")
+)
+
+type searchCacheEntry struct {
+	key        string
+	results    PlaceResults
+	fetched    time.Time
+	refreshing bool
+}
+
+// searchCache is a PlaceProvider that remembers the searches of next, so
+// people asking for the same food from about the same place share one
+// search. Coordinates are rounded to a geohash cell of precision characters.
+// Results are fresh for ttl; for stale after that they are still served
+// while one background search refreshes them. It holds at most size
+// searches, evicting the least recently used.
+type searchCache struct {
+	next      PlaceProvider
+	ttl       time.Duration
+	stale     time.Duration
+	size      int
+	precision int
+	mu        sync.Mutex
+	order     *list.List
+	items     map[string]*list.Element
+}
+
+func newSearchCache(next PlaceProvider, ttl, stale time.Duration, size, precision int) *searchCache {
+	if size < 1 {
+		size = 1
+	}
+	if precision < 1 {
+		precision = 1
+	}
+	return &searchCache{
+		next:      next,
+		ttl:       ttl,
+		stale:     stale,
+		size:      size,
+		precision: precision,
+		order:     list.New(),
+		items:     make(map[string]*list.Element),
+	}
+}
+
+func (c *searchCache) SearchByCoordinate(q PlaceQuery, latitude, longitude float64) (PlaceResults, error) {
+	key := "coord|" + geohash(latitude, longitude, c.precision) + "|" + queryKey(q)
+	results, err := c.lookup(key, func() (PlaceResults, error) {
+		return c.next.SearchByCoordinate(q, latitude, longitude)
+	})
+	if err != nil {
+		return PlaceResults{}, err
+	}
+	return measureFrom(results, q, latitude, longitude), nil
+}
+
+// SearchByText shares results between texts that normalize the same. The
+// hint is left out of the key: it is only where the text was geocoded to,
+// which is coarser than an address.
+func (c *searchCache) SearchByText(q PlaceQuery, location string, hint *LatLng) (PlaceResults, error) {
+	key := "text|" + normalizePlace(location) + "|" + queryKey(q)
+	return c.lookup(key, func() (PlaceResults, error) {
+		return c.next.SearchByText(q, location, hint)
+	})
+}
+
+// SuggestTerms passes through to next, which may not suggest anything.
+func (c *searchCache) SuggestTerms(text string, near *LatLng) ([]string, error) {
+	if s, ok := c.next.(termSuggester); ok {
+		return s.SuggestTerms(text, near)
+	}
+	return nil, nil
+}
+
+// Details is not cached; it is asked for one place at a time.
+func (c *searchCache) Details(id string) (Place, error) {
+	return c.next.Details(id)
+}
+
+// lookup returns the results cached under key, calling fetch on a miss or in
+// the background once they are stale. Errors are never cached.
+func (c *searchCache) lookup(key string, fetch func() (PlaceResults, error)) (PlaceResults, error) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*searchCacheEntry)
+		age := time.Since(e.fetched)
+		if age < c.ttl+c.stale {
+			c.order.MoveToFront(el)
+			results := copyResults(e.results)
+			if age < c.ttl {
+				searchCacheHits.Add(1)
+			} else {
+				searchCacheStaleHits.Add(1)
+				if !e.refreshing {
+					e.refreshing = true
+					go c.refresh(key, fetch)
+				}
+			}
+			c.mu.Unlock()
+			return results, nil
+		}
+	}
+	c.mu.Unlock()
+
+	searchCacheMisses.Add(1)
+	results, err := fetch()
+	if err != nil {
+		return PlaceResults{}, err
+	}
+	c.mu.Lock()
+	c.put(key, results)
+	c.mu.Unlock()
+	return copyResults(results), nil
+}
+
+func (c *searchCache) refresh(key string, fetch func() (PlaceResults, error)) {
+	results, err := fetch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		searchCacheRefreshes.Add(1)
+		log.Println(err)
+		if el, ok := c.items[key]; ok {
+			el.Value.(*searchCacheEntry).refreshing = false
+		}
+		return
+	}
+	c.put(key, results)
+}
+
+// put adds or replaces key as fetched now. Callers hold c.mu.
+func (c *searchCache) put(key string, results PlaceResults) {
+	if el, ok := c.items[key]; ok {
+		el.Value = &searchCacheEntry{key: key, results: results, fetched: time.Now()}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&searchCacheEntry{key: key, results: results, fetched: time.Now()})
+	searchCacheEntries.Add(1)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*searchCacheEntry).key)
+		searchCacheEntries.Add(-1)
+	}
+}
+
+// copyResults keeps callers from reordering the cached slice.
+func copyResults(r PlaceResults) PlaceResults {
+	r.Places = append([]Place(nil), r.Places...)
+	return r
+}
+
+// measureFrom sets distances from where this search was made rather than
+// where the cached one was, drops what is now outside q's radius, and sorts
+// again if q asked for the nearest.
+func measureFrom(r PlaceResults, q PlaceQuery, latitude, longitude float64) PlaceResults {
+	kept := r.Places[:0]
+	for _, p := range r.Places {
+		if p.Latitude != 0 || p.Longitude != 0 {
+			p.Distance = distanceMeters(latitude, longitude, p.Latitude, p.Longitude)
+			if q.Radius.Valid && q.Radius.Float64 > 0 && p.Distance > q.Radius.Float64 {
+				r.Total--
+				continue
+			}
+		}
+		kept = append(kept, p)
+	}
+	r.Places = kept
+	if q.Sort.Valid && q.Sort.Int64 == sortDistance {
+		sort.SliceStable(r.Places, func(i, j int) bool {
+			return r.Places[i].Distance < r.Places[j].Distance
+		})
+	}
+	return r
+}
+
+// queryKey writes q in one canonical form: the term lower-cased with its
+// spacing folded, and the categories sorted.
+func queryKey(q PlaceQuery) string {
+	term := strings.Join(strings.Fields(strings.ToLower(strings.Replace(q.Term, "臺", "台", -1))), " ")
+	categories := append([]string(nil), q.Categories...)
+	sort.Strings(categories)
+	parts := []string{
+		term,
+		strings.Join(categories, ","),
+		strconv.FormatBool(q.OpenNow),
+		strconv.Itoa(q.MaxPrice),
+		strconv.Itoa(q.Limit),
+		strconv.Itoa(q.Offset),
+	}
+	if q.Radius.Valid {
+		parts = append(parts, "r"+strconv.FormatFloat(q.Radius.Float64, 'f', -1, 64))
+	}
+	if q.Sort.Valid {
+		parts = append(parts, "s"+strconv.FormatInt(q.Sort.Int64, 10))
+	}
+	if q.Deals.Valid {
+		parts = append(parts, "d"+strconv.FormatBool(q.Deals.Bool))
+	}
+	if !q.OpenAt.IsZero() {
+		// to the quarter hour, so polls started close together share
+		parts = append(parts, "o"+strconv.FormatInt(q.OpenAt.Unix()/900, 10))
+	}
+	return strings.Join(parts, "|")
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohash encodes a point as a geohash of precision characters. Six
+// characters make a cell of about 1.2 by 0.6 km.
+func geohash(latitude, longitude float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+	hash := make([]byte, 0, precision)
+	even := true
+	bit, ch := 0, 0
+	for len(hash) < precision {
+		r, v := &latRange, latitude
+		if even {
+			r, v = &lngRange, longitude
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			hash = append(hash, geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
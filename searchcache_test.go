@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guregu/null"
+)
+
+func TestGeohash(t *testing.T) {
+	tests := []struct {
+		latitude, longitude float64
+		precision           int
+		want                string
+	}{
+		{57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{25.0336, 121.5646, 6, "wsqqqm"},
+		{-33.8568, 151.2153, 7, "r3gx2ux"},
+	}
+	for _, tt := range tests {
+		if got := geohash(tt.latitude, tt.longitude, tt.precision); got != tt.want {
+			t.Errorf("geohash(%v, %v, %d) = %q, want %q", tt.latitude, tt.longitude, tt.precision, got, tt.want)
+		}
+	}
+}
+
+// countingProvider returns one place and counts the searches it was asked.
+type countingProvider struct {
+	mu       sync.Mutex
+	searches int
+}
+
+func (p *countingProvider) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.searches
+}
+
+func (p *countingProvider) SearchByCoordinate(q PlaceQuery, latitude, longitude float64) (PlaceResults, error) {
+	p.mu.Lock()
+	p.searches++
+	p.mu.Unlock()
+	return PlaceResults{Total: 1, Places: []Place{{ID: "ramen", Latitude: 25.0340, Longitude: 121.5650}}}, nil
+}
+
+func (p *countingProvider) SearchByText(q PlaceQuery, location string, hint *LatLng) (PlaceResults, error) {
+	return p.SearchByCoordinate(q, 0, 0)
+}
+
+func (p *countingProvider) Details(id string) (Place, error) {
+	return Place{}, errPlaceNotFound
+}
+
+func TestSearchCacheSharesCell(t *testing.T) {
+	next := &countingProvider{}
+	c := newSearchCache(next, time.Minute, time.Minute, 10, 6)
+
+	a, _ := c.SearchByCoordinate(PlaceQuery{Term: "拉麵"}, 25.0336, 121.5646)
+	b, _ := c.SearchByCoordinate(PlaceQuery{Term: " 拉麵 "}, 25.0338, 121.5648)
+	if next.count() != 1 {
+		t.Fatalf("searched %d times for one cell", next.count())
+	}
+	if a.Places[0].Distance == b.Places[0].Distance {
+		t.Errorf("distance not measured from each asker: %v", a.Places[0].Distance)
+	}
+
+	c.SearchByCoordinate(PlaceQuery{Term: "壽司"}, 25.0336, 121.5646)
+	if next.count() != 2 {
+		t.Errorf("another term shared the cached search")
+	}
+}
+
+func TestSearchCacheRadius(t *testing.T) {
+	next := &countingProvider{}
+	c := newSearchCache(next, time.Minute, time.Minute, 10, 4)
+
+	// the place is about 50 m from the first asker and 2 km from the second,
+	// who is still in the same 20 km cell
+	q := PlaceQuery{Radius: null.FloatFrom(500)}
+	if r, _ := c.SearchByCoordinate(q, 25.0336, 121.5646); len(r.Places) != 1 {
+		t.Fatalf("first asker got %d places", len(r.Places))
+	}
+	if r, _ := c.SearchByCoordinate(q, 25.0160, 121.5646); len(r.Places) != 0 {
+		t.Errorf("place %v m away kept for a 500 m search", r.Places[0].Distance)
+	}
+}
+
+func TestSearchCacheStaleWhileRevalidate(t *testing.T) {
+	next := &countingProvider{}
+	c := newSearchCache(next, 20*time.Millisecond, time.Minute, 10, 6)
+
+	c.SearchByCoordinate(PlaceQuery{}, 25.0336, 121.5646)
+	time.Sleep(30 * time.Millisecond)
+	if _, err := c.SearchByCoordinate(PlaceQuery{}, 25.0336, 121.5646); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && next.count() < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if next.count() != 2 {
+		t.Fatalf("stale entry refreshed %d times", next.count()-1)
+	}
+	c.SearchByCoordinate(PlaceQuery{}, 25.0336, 121.5646)
+	if next.count() != 2 {
+		t.Errorf("refreshed entry searched again")
+	}
+}
+
+func TestSearchCacheEvicts(t *testing.T) {
+	next := &countingProvider{}
+	c := newSearchCache(next, time.Minute, time.Minute, 2, 6)
+	for _, term := range []string{"a", "b", "c", "a"} {
+		c.SearchByCoordinate(PlaceQuery{Term: term}, 25.0336, 121.5646)
+	}
+	if next.count() != 4 {
+		t.Errorf("searched %d times, want the evicted term searched again", next.count())
+	}
+}
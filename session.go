@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Session holds where a user is in the conversation.
+type Session struct {
+	State   DialogState `json:"state"`
+	Food    string      `json:"food"`
+	Search  *lastSearch `json:"search,omitempty"`
+	Places  []geoMatch  `json:"places,omitempty"`
+	Updated time.Time   `json:"updated"`
+}
+
+// SessionStore keeps one Session per LINE user. Sessions that have not been
+// updated within the store's TTL are treated as missing.
+type SessionStore interface {
+	Get(user string) (Session, bool)
+	Put(user string, s Session) error
+	Delete(user string) error
+}
+
+type memorySessionStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	sessions  map[string]Session
+	lastSweep time.Time
+}
+
+// newMemorySessionStore returns a SessionStore that lives only in memory.
+func newMemorySessionStore(ttl time.Duration) *memorySessionStore {
+	return &memorySessionStore{
+		ttl:       ttl,
+		sessions:  make(map[string]Session),
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *memorySessionStore) expired(s Session, now time.Time) bool {
+	return m.ttl > 0 && now.Sub(s.Updated) > m.ttl
+}
+
+func (m *memorySessionStore) Get(user string) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[user]
+	if !ok {
+		return Session{}, false
+	}
+	if m.expired(s, time.Now()) {
+		delete(m.sessions, user)
+		return Session{}, false
+	}
+	return s, true
+}
+
+func (m *memorySessionStore) Put(user string, s Session) error {
+	if s.Updated.IsZero() {
+		s.Updated = time.Now()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[user] = s
+	m.sweep(time.Now())
+	return nil
+}
+
+func (m *memorySessionStore) Delete(user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, user)
+	return nil
+}
+
+// sweep drops expired sessions, at most once per TTL. Callers hold m.mu.
+func (m *memorySessionStore) sweep(now time.Time) {
+	if m.ttl <= 0 || now.Sub(m.lastSweep) < m.ttl {
+		return
+	}
+	for user, s := range m.sessions {
+		if m.expired(s, now) {
+			delete(m.sessions, user)
+		}
+	}
+	m.lastSweep = now
+}
+
+type sessionRecord struct {
+	User    string   `json:"user"`
+	Session *Session `json:"session,omitempty"`
+}
+
+type fileSessionStore struct {
+	*memorySessionStore
+	journal *journal
+}
+
+// openSessionStore returns a SessionStore backed by an append-only journal at
+// path, so sessions survive a restart.
+func openSessionStore(path string, ttl time.Duration) (*fileSessionStore, error) {
+	mem := newMemorySessionStore(ttl)
+	j, err := openJournal(path, func(line []byte) error {
+		var r sessionRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if r.Session == nil {
+			delete(mem.sessions, r.User)
+		} else {
+			mem.sessions[r.User] = *r.Session
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f := &fileSessionStore{memorySessionStore: mem, journal: j}
+	if err := j.Compact(f.live()); err != nil {
+		j.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// live drops expired sessions and returns the rest as records. Callers hold
+// f.mu, or own f exclusively.
+func (f *fileSessionStore) live() []interface{} {
+	now := time.Now()
+	var live []interface{}
+	for user, s := range f.sessions {
+		if f.expired(s, now) {
+			delete(f.sessions, user)
+			continue
+		}
+		s := s
+		live = append(live, sessionRecord{User: user, Session: &s})
+	}
+	return live
+}
+
+func (f *fileSessionStore) Put(user string, s Session) error {
+	if s.Updated.IsZero() {
+		s.Updated = time.Now()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions[user] = s
+	f.sweep(time.Now())
+	return f.append(sessionRecord{User: user, Session: &s})
+}
+
+func (f *fileSessionStore) Delete(user string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sessions, user)
+	return f.append(sessionRecord{User: user})
+}
+
+// append journals r. Callers hold f.mu.
+func (f *fileSessionStore) append(r sessionRecord) error {
+	if err := f.journal.Append(r); err != nil {
+		return err
+	}
+	return f.journal.CompactIfDue(f.live)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"expvar"
+	"log"
+	"strings"
+	"sync"
+)
+
+// short url cache metrics, published on /debug/vars
+var (
+	shortCacheHits   = expvar.NewInt("shorturl_cache_hits")
+	shortCacheMisses = expvar.NewInt("shorturl_cache_misses")
+)
+
+// prefetchWorkers bounds the concurrent shortening calls of one prefetch.
+const prefetchWorkers = 8
+
+// shortCacheEntry is a cached short URL. In the journal, an entry with no
+// Short removes Key.
+type shortCacheEntry struct {
+	Key   string `json:"key"`
+	Short string `json:"short"`
+}
+
+// shortURLCache remembers the short form of recently shortened URLs, evicting
+// the least recently used once it holds size entries.
+type shortURLCache struct {
+	next    Shortener
+	size    int
+	mu      sync.Mutex
+	order   *list.List
+	items   map[string]*list.Element
+	journal *journal
+}
+
+func newShortURLCache(next Shortener, size int) *shortURLCache {
+	if size < 1 {
+		size = 1
+	}
+	return &shortURLCache{
+		next:  next,
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// openShortURLCache returns a shortURLCache that journals its entries to path
+// so a restart does not shorten every URL again.
+func openShortURLCache(path string, next Shortener, size int) (*shortURLCache, error) {
+	c := newShortURLCache(next, size)
+	j, err := openJournal(path, func(line []byte) error {
+		var e shortCacheEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return err
+		}
+		if e.Short == "" {
+			c.remove(e.Key)
+		} else {
+			c.put(e.Key, e.Short)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := j.Compact(c.live()); err != nil {
+		j.Close()
+		return nil, err
+	}
+	c.journal = j
+	return c, nil
+}
+
+func (c *shortURLCache) Shorten(longURL string) (string, error) {
+	return c.ShortenTracked(longURL, "", "")
+}
+
+// ShortenTracked returns the cached short URL for longURL, shortening it on
+// a miss. Links from the bot's own link store are cached per user, since
+// each user gets their own code there.
+func (c *shortURLCache) ShortenTracked(longURL, business, user string) (string, error) {
+	if longURL == "" {
+		return "", nil
+	}
+	t, tracking := c.next.(trackingShortener)
+	key := longURL
+	if perUserCodes(c.next) {
+		key = linkKey(longURL, user)
+	}
+	if short, ok := c.get(key); ok {
+		shortCacheHits.Add(1)
+		return short, nil
+	}
+	shortCacheMisses.Add(1)
+
+	var short string
+	var err error
+	if tracking {
+		short, err = t.ShortenTracked(longURL, business, user)
+	} else {
+		short, err = c.next.Shorten(longURL)
+	}
+	if err != nil {
+		return "", err
+	}
+	// a chain falls back to the long URL; keep trying the real thing next time
+	if short == "" || short == longURL {
+		return short, nil
+	}
+
+	c.mu.Lock()
+	c.put(key, short)
+	c.append(shortCacheEntry{Key: key, Short: short})
+	c.mu.Unlock()
+	return short, nil
+}
+
+// live returns the cached entries oldest first, so replaying the compacted
+// journal keeps the LRU order. Callers hold c.mu, or own c exclusively.
+func (c *shortURLCache) live() []interface{} {
+	var live []interface{}
+	for el := c.order.Back(); el != nil; el = el.Prev() {
+		live = append(live, *el.Value.(*shortCacheEntry))
+	}
+	return live
+}
+
+// append journals e. Callers hold c.mu.
+func (c *shortURLCache) append(e shortCacheEntry) {
+	if c.journal == nil {
+		return
+	}
+	if err := c.journal.Append(e); err != nil {
+		log.Println(err)
+	}
+	if err := c.journal.CompactIfDue(c.live); err != nil {
+		log.Println(err)
+	}
+}
+
+// perUserCodes reports whether s, or a backend s falls back to, issues each
+// user their own code for the same URL.
+func perUserCodes(s Shortener) bool {
+	switch s := s.(type) {
+	case *linkStore:
+		return true
+	case shortenerChain:
+		for _, next := range s {
+			if perUserCodes(next) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *shortURLCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*shortCacheEntry).Short, true
+}
+
+// put adds or refreshes key. Callers hold c.mu.
+func (c *shortURLCache) put(key, short string) {
+	if el, ok := c.items[key]; ok {
+		el.Value.(*shortCacheEntry).Short = short
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&shortCacheEntry{Key: key, Short: short})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*shortCacheEntry).Key)
+	}
+}
+
+// remove drops key. Callers hold c.mu.
+func (c *shortURLCache) remove(key string) {
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// Forget drops the short URLs cached for user.
+func (c *shortURLCache) Forget(user string) {
+	if user == "" {
+		return
+	}
+	prefix := linkKey("", user)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(key)
+			c.append(shortCacheEntry{Key: key})
+		}
+	}
+}
+
+// prefetchShortLinks shortens the mobile URLs of businesses concurrently so
+// the links are cached by the time each recommendation is sent. Callers pass
+// only what they are about to send, as every call may cost a request to the
+// backend or a code kept forever.
+func prefetchShortLinks(user string, businesses []Place) {
+	jobs := make(chan Place)
+	var wg sync.WaitGroup
+	for i := 0; i < prefetchWorkers && i < len(businesses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range jobs {
+				urlOrig := UrlShortener{}
+				urlOrig.shortFor(b.URL, b.ID, user)
+			}
+		}()
+	}
+	for _, b := range businesses {
+		jobs <- b
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingShortener issues "short/<user>/<url>" and counts the URLs it was
+// asked for.
+type countingShortener struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *countingShortener) Shorten(longURL string) (string, error) {
+	return c.ShortenTracked(longURL, "", "")
+}
+
+func (c *countingShortener) ShortenTracked(longURL, business, user string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls == nil {
+		c.calls = make(map[string]int)
+	}
+	c.calls[longURL]++
+	return "https://sho.rt/" + user + "/" + strings.TrimPrefix(longURL, "https://yelp.com/biz/"), nil
+}
+
+func (c *countingShortener) count(longURL string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[longURL]
+}
+
+func TestShortURLCacheLRU(t *testing.T) {
+	next := &countingShortener{}
+	c := newShortURLCache(next, 2)
+	c.Shorten("https://yelp.com/biz/a")
+	c.Shorten("https://yelp.com/biz/b")
+	c.Shorten("https://yelp.com/biz/a")
+	c.Shorten("https://yelp.com/biz/c") // evicts b, the least recently used
+	c.Shorten("https://yelp.com/biz/a")
+	c.Shorten("https://yelp.com/biz/b")
+
+	for url, want := range map[string]int{"a": 1, "b": 2, "c": 1} {
+		if got := next.count("https://yelp.com/biz/" + url); got != want {
+			t.Errorf("%s shortened %d times, want %d", url, got, want)
+		}
+	}
+}
+
+func TestShortURLCacheKeys(t *testing.T) {
+	// a shared backend gives everyone the same link
+	shared := &countingShortener{}
+	c := newShortURLCache(shared, 10)
+	a, _ := c.ShortenTracked("https://yelp.com/biz/pho", "pho", "alice")
+	b, _ := c.ShortenTracked("https://yelp.com/biz/pho", "pho", "bob")
+	if a != b || shared.count("https://yelp.com/biz/pho") != 1 {
+		t.Errorf("shared backend: %s and %s after %d calls", a, b, shared.count("https://yelp.com/biz/pho"))
+	}
+
+	// the bot's own links are per user, even behind a chain
+	links := newLinkStore("https://bot.example.com")
+	c = newShortURLCache(shortenerChain{links}, 10)
+	a, _ = c.ShortenTracked("https://yelp.com/biz/pho", "pho", "alice")
+	b, _ = c.ShortenTracked("https://yelp.com/biz/pho", "pho", "bob")
+	again, _ := c.ShortenTracked("https://yelp.com/biz/pho", "pho", "alice")
+	if a == b || a != again {
+		t.Errorf("own links: alice %s then %s, bob %s", a, again, b)
+	}
+	if !perUserCodes(shortenerChain{isGdShortener, links}) || perUserCodes(shortenerChain{isGdShortener}) {
+		t.Error("perUserCodes misjudges a chain")
+	}
+}
+
+func TestShortURLCacheJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shorturls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shorturls.log")
+
+	next := &countingShortener{}
+	c, err := openShortURLCache(path, next, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Shorten("https://yelp.com/biz/a")
+	c.Shorten("https://yelp.com/biz/b")
+	c.journal.Close()
+
+	// a is still the oldest after the restart, so c evicts it
+	c, err = openShortURLCache(path, next, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.journal.Close()
+	c.Shorten("https://yelp.com/biz/c")
+	c.Shorten("https://yelp.com/biz/b")
+	c.Shorten("https://yelp.com/biz/a")
+	for url, want := range map[string]int{"a": 2, "b": 1, "c": 1} {
+		if got := next.count("https://yelp.com/biz/" + url); got != want {
+			t.Errorf("%s shortened %d times, want %d", url, got, want)
+		}
+	}
+}
+
+func TestShortURLCacheForget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shorturls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shorturls.log")
+
+	links := newLinkStore("https://bot.example.com")
+	c, err := openShortURLCache(path, links, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ShortenTracked("https://yelp.com/biz/a", "a", "alice")
+	c.ShortenTracked("https://yelp.com/biz/b", "b", "alice")
+	c.ShortenTracked("https://yelp.com/biz/a", "a", "bob")
+	c.Forget("alice")
+	c.journal.Close()
+
+	c, err = openShortURLCache(path, links, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.journal.Close()
+	for key := range c.items {
+		if strings.HasPrefix(key, "alice ") {
+			t.Errorf("%q kept after forgetting alice", key)
+		}
+	}
+	if _, ok := c.get(linkKey("https://yelp.com/biz/a", "bob")); !ok {
+		t.Error("bob's link was forgotten too")
+	}
+}
+
+func TestPrefetchShortLinks(t *testing.T) {
+	saved := shortener
+	defer func() { shortener = saved }()
+	next := &countingShortener{}
+	shortener = newShortURLCache(next, 100)
+
+	var places []Place
+	for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		places = append(places, Place{ID: id, URL: "https://yelp.com/biz/" + id})
+	}
+	prefetchShortLinks("alice", places)
+	for _, p := range places {
+		if next.count(p.URL) != 1 {
+			t.Errorf("%s prefetched %d times", p.ID, next.count(p.URL))
+		}
+	}
+
+	// sending them afterwards is served from the cache
+	for _, p := range places {
+		u := UrlShortener{}
+		u.shortFor(p.URL, p.ID, "alice")
+		if u.ShortUrl != "https://sho.rt/alice/"+p.ID || next.count(p.URL) != 1 {
+			t.Errorf("%s: %s after %d calls", p.ID, u.ShortUrl, next.count(p.URL))
+		}
+	}
+}
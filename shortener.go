@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Shortener turns a long URL into a short one.
+type Shortener interface {
+	Shorten(longURL string) (string, error)
+}
+
+// trackingShortener is a Shortener that can attribute a link to the
+// business and user it was issued for.
+type trackingShortener interface {
+	Shortener
+	ShortenTracked(longURL, business, user string) (string, error)
+}
+
+var errEmptyShortURL = errors.New("shortener returned an empty url")
+
+var shortenerHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+type UrlShortener struct {
+	ShortUrl    string
+	OriginalUrl string
+}
+
+// shortFor shortens urlOrig, a link recommended to user as business.
+func (u *UrlShortener) shortFor(urlOrig, business, user string) *UrlShortener {
+	u.OriginalUrl = urlOrig
+	var shortUrl string
+	var err error
+	if t, ok := shortener.(trackingShortener); ok {
+		shortUrl, err = t.ShortenTracked(urlOrig, business, user)
+	} else {
+		shortUrl, err = shortener.Shorten(urlOrig)
+	}
+	if err != nil {
+		log.Println(err)
+		shortUrl = urlOrig
+	}
+	u.ShortUrl = shortUrl
+	return u
+}
+
+func getResponseData(urlOrig string) (string, error) {
+	response, err := shortenerHTTPClient.Get(urlOrig)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", urlOrig, response.Status)
+	}
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// templateShortener calls a shortening service whose API takes the long URL
+// in the query string and answers with the short URL as plain text. The
+// template marks where the escaped URL goes with {url}.
+type templateShortener struct {
+	Template string
+}
+
+func (t templateShortener) Shorten(longURL string) (string, error) {
+	api := strings.Replace(t.Template, "{url}", url.QueryEscape(longURL), -1)
+	shortUrl, err := getResponseData(api)
+	if err != nil {
+		return "", err
+	}
+	if shortUrl == "" {
+		return "", errEmptyShortURL
+	}
+	if !strings.HasPrefix(shortUrl, "http://") && !strings.HasPrefix(shortUrl, "https://") {
+		return "", fmt.Errorf("shortener returned %q", shortUrl)
+	}
+	return shortUrl, nil
+}
+
+// isGdShortener uses the public is.gd service.
+var isGdShortener = templateShortener{Template: "http://is.gd/create.php?url={url}&format=simple"}
+
+// passthroughShortener hands back the URL unchanged.
+type passthroughShortener struct{}
+
+func (passthroughShortener) Shorten(longURL string) (string, error) {
+	return longURL, nil
+}
+
+// shortenerChain tries each backend in turn and falls back to the original
+// URL when all of them fail, so callers always get a usable link.
+type shortenerChain []Shortener
+
+func (c shortenerChain) Shorten(longURL string) (string, error) {
+	return c.ShortenTracked(longURL, "", "")
+}
+
+func (c shortenerChain) ShortenTracked(longURL, business, user string) (string, error) {
+	if longURL == "" {
+		return "", nil
+	}
+	for _, s := range c {
+		var shortUrl string
+		var err error
+		if t, ok := s.(trackingShortener); ok {
+			shortUrl, err = t.ShortenTracked(longURL, business, user)
+		} else {
+			shortUrl, err = s.Shorten(longURL)
+		}
+		if err == nil && shortUrl != "" {
+			return shortUrl, nil
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return longURL, nil
+}
+
+// newShortenerChain builds a chain from a comma separated list of backend
+// names: self (the bot's own /s/ links), isgd, template (SHORTENER_TEMPLATE)
+// and none. An empty list means self then is.gd when links is set, and
+// is.gd alone otherwise.
+func newShortenerChain(names string, links *linkStore) (Shortener, error) {
+	if names == "" {
+		names = "isgd"
+		if links != nil {
+			names = "self,isgd"
+		}
+	}
+	var chain shortenerChain
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "self":
+			if links == nil {
+				return nil, errors.New("Wrong environment setting about BASE_URL")
+			}
+			chain = append(chain, links)
+		case "isgd":
+			chain = append(chain, isGdShortener)
+		case "template":
+			tmpl := os.Getenv("SHORTENER_TEMPLATE")
+			if !strings.Contains(tmpl, "{url}") {
+				return nil, errors.New("Wrong environment setting about SHORTENER_TEMPLATE")
+			}
+			chain = append(chain, templateShortener{Template: tmpl})
+		case "none":
+			chain = append(chain, passthroughShortener{})
+		default:
+			return nil, fmt.Errorf("unknown shortener %q", name)
+		}
+	}
+	return chain, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// fakeShortener answers with short, or fails with err, and counts its calls.
+type fakeShortener struct {
+	short string
+	err   error
+	calls int
+}
+
+func (f *fakeShortener) Shorten(longURL string) (string, error) {
+	f.calls++
+	return f.short, f.err
+}
+
+func TestTemplateShortener(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("url") {
+		case "https://yelp.com/biz/pho?a=1&b=2":
+			fmt.Fprintln(w, "https://sho.rt/pho")
+		case "https://yelp.com/biz/empty":
+		case "https://yelp.com/biz/html":
+			fmt.Fprint(w, "<html>rate limited</html>")
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	s := templateShortener{Template: server.URL + "/create?url={url}"}
+
+	if got, err := s.Shorten("https://yelp.com/biz/pho?a=1&b=2"); err != nil || got != "https://sho.rt/pho" {
+		t.Errorf("Shorten = %q, %v", got, err)
+	}
+	for _, long := range []string{"https://yelp.com/biz/empty", "https://yelp.com/biz/html", "https://yelp.com/biz/down"} {
+		if got, err := s.Shorten(long); err == nil {
+			t.Errorf("Shorten(%s) = %q without an error", long, got)
+		}
+	}
+}
+
+func TestShortenerChainFallsBack(t *testing.T) {
+	down := &fakeShortener{err: errors.New("down")}
+	empty := &fakeShortener{}
+	working := &fakeShortener{short: "https://sho.rt/a"}
+	unused := &fakeShortener{short: "https://other.rt/a"}
+
+	chain := shortenerChain{down, empty, working, unused}
+	if got, err := chain.Shorten("https://yelp.com/biz/a"); err != nil || got != "https://sho.rt/a" {
+		t.Errorf("Shorten = %q, %v", got, err)
+	}
+	if down.calls != 1 || empty.calls != 1 || working.calls != 1 || unused.calls != 0 {
+		t.Errorf("calls = %d, %d, %d, %d", down.calls, empty.calls, working.calls, unused.calls)
+	}
+
+	// with every backend failing the long URL still goes out
+	chain = shortenerChain{down, empty}
+	if got, err := chain.Shorten("https://yelp.com/biz/a"); err != nil || got != "https://yelp.com/biz/a" {
+		t.Errorf("all failing: Shorten = %q, %v", got, err)
+	}
+	if got, _ := chain.Shorten(""); got != "" {
+		t.Errorf("empty URL shortened to %q", got)
+	}
+}
+
+func TestNewShortenerChain(t *testing.T) {
+	links := newLinkStore("https://bot.example.com")
+	tests := []struct {
+		names string
+		links *linkStore
+		want  int
+		err   bool
+	}{
+		{"", nil, 1, false},
+		{"", links, 2, false},
+		{"self, isgd, none", links, 3, false},
+		{"self", nil, 0, true},
+		{"template", nil, 0, true},
+		{"bitly", nil, 0, true},
+	}
+	os.Unsetenv("SHORTENER_TEMPLATE")
+	for _, tt := range tests {
+		s, err := newShortenerChain(tt.names, tt.links)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: err = %v", tt.names, err)
+			continue
+		}
+		if err == nil && len(s.(shortenerChain)) != tt.want {
+			t.Errorf("%q: %d backends, want %d", tt.names, len(s.(shortenerChain)), tt.want)
+		}
+	}
+	if s, _ := newShortenerChain("", links); s.(shortenerChain)[0] != Shortener(links) {
+		t.Error("the default chain does not start with the bot's own links")
+	}
+}
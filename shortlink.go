@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const shortCodeAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const shortCodeLength = 6
+
+// clicks on self-hosted links, published on /debug/vars
+var linkClicks = expvar.NewInt("link_clicks")
+
+// shortLink is one code issued by the bot's own shortener.
+type shortLink struct {
+	Code     string    `json:"code"`
+	URL      string    `json:"url"`
+	Business string    `json:"business,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Created  time.Time `json:"created"`
+	Clicks   int       `json:"clicks"`
+}
+
+// linkRecord is one line of the journal: a code issued, a click on a code,
+// or a user whose codes were forgotten.
+type linkRecord struct {
+	Link   *shortLink `json:"link,omitempty"`
+	Click  string     `json:"click,omitempty"`
+	Forget string     `json:"forget,omitempty"`
+}
+
+// linkStore issues short codes served from /s/{code} and counts how often
+// each one is opened.
+type linkStore struct {
+	mu      sync.Mutex
+	baseURL string
+	codes   map[string]*shortLink
+	byKey   map[string]*shortLink
+	journal *journal
+}
+
+func newLinkStore(baseURL string) *linkStore {
+	return &linkStore{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		codes:   make(map[string]*shortLink),
+		byKey:   make(map[string]*shortLink),
+	}
+}
+
+// openLinkStore returns a linkStore that keeps its codes and clicks in a
+// journal at path.
+func openLinkStore(path, baseURL string) (*linkStore, error) {
+	s := newLinkStore(baseURL)
+	j, err := openJournal(path, func(line []byte) error {
+		var r linkRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		if r.Link != nil {
+			s.add(r.Link)
+		} else if r.Forget != "" {
+			s.forget(r.Forget)
+		} else if l, ok := s.codes[r.Click]; ok {
+			l.Clicks++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := j.Compact(s.live()); err != nil {
+		j.Close()
+		return nil, err
+	}
+	s.journal = j
+	return s, nil
+}
+
+func linkKey(longURL, user string) string {
+	return user + " " + longURL
+}
+
+func (s *linkStore) add(l *shortLink) {
+	s.codes[l.Code] = l
+	s.byKey[linkKey(l.URL, l.User)] = l
+}
+
+// forget drops the codes issued to user. Callers hold s.mu.
+func (s *linkStore) forget(user string) {
+	for code, l := range s.codes {
+		if l.User == user {
+			delete(s.codes, code)
+			delete(s.byKey, linkKey(l.URL, l.User))
+		}
+	}
+}
+
+// live returns every code, with its clicks, as a record. Callers hold s.mu,
+// or own s exclusively.
+func (s *linkStore) live() []interface{} {
+	var live []interface{}
+	for _, l := range s.codes {
+		live = append(live, linkRecord{Link: l})
+	}
+	return live
+}
+
+// append journals r. Callers hold s.mu.
+func (s *linkStore) append(r linkRecord) {
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.Append(r); err != nil {
+		log.Println(err)
+	}
+	if err := s.journal.CompactIfDue(s.live); err != nil {
+		log.Println(err)
+	}
+}
+
+func newShortCode() (string, error) {
+	max := big.NewInt(int64(len(shortCodeAlphabet)))
+	code := make([]byte, shortCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = shortCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Shorten issues a code that is not attributed to any business or user.
+func (s *linkStore) Shorten(longURL string) (string, error) {
+	return s.ShortenTracked(longURL, "", "")
+}
+
+// ShortenTracked issues a code for longURL recommended to user as business.
+// The same user gets the same code for the same URL.
+func (s *linkStore) ShortenTracked(longURL, business, user string) (string, error) {
+	if s.baseURL == "" {
+		return "", errors.New("self-hosted shortener needs BASE_URL")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.byKey[linkKey(longURL, user)]; ok {
+		return s.baseURL + "/s/" + l.Code, nil
+	}
+
+	var code string
+	for {
+		c, err := newShortCode()
+		if err != nil {
+			return "", err
+		}
+		if _, taken := s.codes[c]; !taken {
+			code = c
+			break
+		}
+	}
+	l := &shortLink{
+		Code:     code,
+		URL:      longURL,
+		Business: business,
+		User:     user,
+		Created:  time.Now(),
+	}
+	s.add(l)
+	s.append(linkRecord{Link: l})
+	return s.baseURL + "/s/" + code, nil
+}
+
+// Open records a click on code and returns the URL it stands for.
+func (s *linkStore) Open(code string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.codes[code]
+	if !ok {
+		return "", false
+	}
+	l.Clicks++
+	linkClicks.Add(1)
+	s.append(linkRecord{Click: code})
+	return l.URL, true
+}
+
+// Forget drops the codes issued to user, so their links stop working and
+// their clicks leave the stats.
+func (s *linkStore) Forget(user string) {
+	if user == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(user)
+	s.append(linkRecord{Forget: user})
+}
+
+// linkStats sums clicks by code, business and user.
+type linkStats struct {
+	ByCode     map[string]int `json:"by_code"`
+	ByBusiness map[string]int `json:"by_business"`
+	ByUser     map[string]int `json:"by_user"`
+}
+
+// Stats returns the click counts collected so far.
+func (s *linkStore) Stats() linkStats {
+	st := linkStats{
+		ByCode:     make(map[string]int),
+		ByBusiness: make(map[string]int),
+		ByUser:     make(map[string]int),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for code, l := range s.codes {
+		st.ByCode[code] = l.Clicks
+		if l.Business != "" {
+			st.ByBusiness[l.Business] += l.Clicks
+		}
+		if l.User != "" {
+			st.ByUser[l.User] += l.Clicks
+		}
+	}
+	return st
+}
+
+// ServeHTTP redirects /s/{code} to the original URL.
+func (s *linkStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/s/")
+	target, ok := s.Open(code)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// statsHandler serves Stats as JSON to callers presenting token.
+func (s *linkStore) statsHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+			w.WriteHeader(403)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(s.Stats()); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func shortCode(t *testing.T, short string) string {
+	code := strings.TrimPrefix(short, "https://bot.example.com/s/")
+	if code == short || len(code) != shortCodeLength {
+		t.Fatalf("short URL %q", short)
+	}
+	return code
+}
+
+func TestLinkStoreCodes(t *testing.T) {
+	s := newLinkStore("https://bot.example.com/")
+	a, err := s.ShortenTracked("https://yelp.com/biz/pho", "pho", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := s.ShortenTracked("https://yelp.com/biz/pho", "pho", "alice")
+	b, _ := s.ShortenTracked("https://yelp.com/biz/pho", "pho", "bob")
+	if a != again {
+		t.Errorf("alice got %s, then %s", a, again)
+	}
+	if a == b {
+		t.Errorf("alice and bob share %s", a)
+	}
+
+	code := shortCode(t, a)
+	for i := 0; i < 2; i++ {
+		if target, ok := s.Open(code); !ok || target != "https://yelp.com/biz/pho" {
+			t.Errorf("Open = %q, %v", target, ok)
+		}
+	}
+	s.Open(shortCode(t, b))
+	if _, ok := s.Open("nope"); ok {
+		t.Error("unknown code opened")
+	}
+
+	st := s.Stats()
+	if st.ByCode[code] != 2 || st.ByBusiness["pho"] != 3 || st.ByUser["alice"] != 2 || st.ByUser["bob"] != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	if _, err := newLinkStore("").Shorten("https://yelp.com/biz/pho"); err == nil {
+		t.Error("shortened without a base URL")
+	}
+}
+
+func TestLinkStoreJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "links.log")
+
+	s, err := openLinkStore(path, "https://bot.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := s.ShortenTracked("https://yelp.com/biz/pho", "pho", "alice")
+	b, _ := s.ShortenTracked("https://yelp.com/biz/pho", "pho", "bob")
+	s.Open(shortCode(t, a))
+	s.Open(shortCode(t, a))
+	s.Open(shortCode(t, b))
+	s.Forget("bob")
+	s.journal.Close()
+
+	s, err = openLinkStore(path, "https://bot.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.journal.Close()
+	if again, _ := s.ShortenTracked("https://yelp.com/biz/pho", "pho", "alice"); again != a {
+		t.Errorf("alice's code changed from %s to %s", a, again)
+	}
+	if st := s.Stats(); st.ByCode[shortCode(t, a)] != 2 || st.ByUser["bob"] != 0 {
+		t.Errorf("stats after a restart = %+v", st)
+	}
+	if _, ok := s.Open(shortCode(t, b)); ok {
+		t.Error("a forgotten user's code still opens")
+	}
+}
+
+func TestLinkStoreHTTP(t *testing.T) {
+	s := newLinkStore("https://bot.example.com")
+	short, _ := s.ShortenTracked("https://yelp.com/biz/pho", "pho", "alice")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/s/"+shortCode(t, short), nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://yelp.com/biz/pho" {
+		t.Errorf("redirect = %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/s/nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown code = %d", w.Code)
+	}
+
+	stats := s.statsHandler("token")
+	w = httptest.NewRecorder()
+	stats(w, httptest.NewRequest("GET", "/stats/links?token=wrong", nil))
+	if w.Code != 403 {
+		t.Errorf("stats with a wrong token = %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	stats(w, httptest.NewRequest("GET", "/stats/links?token=token", nil))
+	var st linkStats
+	if err := json.NewDecoder(w.Body).Decode(&st); err != nil || st.ByUser["alice"] != 1 {
+		t.Errorf("stats = %+v, %v", st, err)
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	// the default zone must load on hosts without a zoneinfo database
+	_ "time/tzdata"
+)
+
+const (
+	// maxSubscriptions is how many daily pushes one user can have.
+	maxSubscriptions = 3
+	// catchUpWindow is how late a push may still go out, for example after
+	// a restart. Later than that, lunch is over and the day is skipped.
+	catchUpWindow = time.Hour
+	// scheduleTick is how often the scheduler looks for due pushes.
+	scheduleTick = 30 * time.Second
+)
+
+const (
+	promptSubscribeHelp    = "每天中午推薦：先'傳送目前位置訊息'，再回覆「訂閱 12:00」或「訂閱 11:30 拉麵」\n回覆「我的訂閱」查看，「取消訂閱」取消"
+	promptNeedLocation     = "請先'傳送目前位置訊息'，我才知道要推薦哪裡的餐廳！"
+	promptTooManySubs      = "最多只能訂閱 3 個時間，請先取消一些"
+	promptNoSubscriptions  = "你還沒有訂閱\n\n" + promptSubscribeHelp
+	promptUnsubscribed     = "已取消訂閱！"
+	promptLunchPush        = "午餐時間到了！今天推薦："
+	promptLunchPushMissing = "午餐時間到了！可惜附近找不到推薦的餐廳"
+)
+
+var (
+	subscribePattern   = regexp.MustCompile(`(?i)^\s*(?:訂閱|subscribe)\s*(\d{1,2})(?:[:：](\d{2})|(點半)|點)?\s*(.*?)\s*$`)
+	unsubscribePattern = regexp.MustCompile(`(?i)^\s*(?:取消訂閱|unsubscribe)\s*(?:(\d{1,2})[:：](\d{2}))?\s*$`)
+)
+
+// savedLocation is where a user last said they are.
+type savedLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// subscription is one daily weekday push. At is "15:04" in Zone; LastFired
+// is the local date it last went out, so a restart neither repeats nor
+// forgets it.
+type subscription struct {
+	At        string        `json:"at"`
+	Zone      string        `json:"zone"`
+	Term      string        `json:"term,omitempty"`
+	Location  savedLocation `json:"location"`
+	LastFired string        `json:"last_fired,omitempty"`
+}
+
+// due reports whether s should go out at now, and the local date to mark it
+// with if so.
+func (s subscription) due(now time.Time) (string, bool) {
+	zone, err := time.LoadLocation(s.Zone)
+	if err != nil {
+		return "", false
+	}
+	local := now.In(zone)
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return "", false
+	}
+	date := local.Format("2006-01-02")
+	if s.LastFired == date {
+		return "", false
+	}
+	at, err := time.ParseInLocation("2006-01-02 15:04", date+" "+s.At, zone)
+	if err != nil {
+		return "", false
+	}
+	if late := local.Sub(at); late < 0 || late > catchUpWindow {
+		return "", false
+	}
+	return date, true
+}
+
+type subscriptionRecord struct {
+	User          string         `json:"user"`
+	Subscriptions []subscription `json:"subscriptions,omitempty"`
+	Location      *savedLocation `json:"location,omitempty"`
+	Forget        bool           `json:"forget,omitempty"`
+}
+
+// subscriptionStore keeps the daily pushes and each user's last location,
+// and runs the scheduler that sends the pushes.
+type subscriptionStore struct {
+	mu        sync.Mutex
+	zone      string
+	subs      map[string][]subscription
+	locations map[string]savedLocation
+	journal   *journal
+
+	stop    chan struct{}
+	done    chan struct{}
+	pushing sync.WaitGroup
+}
+
+func newSubscriptionStore(zone string) *subscriptionStore {
+	return &subscriptionStore{
+		zone:      zone,
+		subs:      make(map[string][]subscription),
+		locations: make(map[string]savedLocation),
+	}
+}
+
+// openSubscriptionStore returns a subscriptionStore journaled to path.
+func openSubscriptionStore(path, zone string) (*subscriptionStore, error) {
+	s := newSubscriptionStore(zone)
+	j, err := openJournal(path, func(line []byte) error {
+		var r subscriptionRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		s.apply(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// older journals kept the location of everyone who sent one
+	for user := range s.locations {
+		if _, ok := s.subs[user]; !ok {
+			delete(s.locations, user)
+		}
+	}
+
+	if err := j.Compact(s.live()); err != nil {
+		j.Close()
+		return nil, err
+	}
+	s.journal = j
+	return s, nil
+}
+
+// apply changes the in-memory state for r: a location record moves the
+// user, any other record replaces their subscriptions. The location is kept
+// only while the user has subscriptions.
+func (s *subscriptionStore) apply(r subscriptionRecord) {
+	switch {
+	case r.Forget:
+		delete(s.subs, r.User)
+		delete(s.locations, r.User)
+	case r.Location != nil:
+		s.locations[r.User] = *r.Location
+	case len(r.Subscriptions) == 0:
+		delete(s.subs, r.User)
+		delete(s.locations, r.User)
+	default:
+		s.subs[r.User] = r.Subscriptions
+	}
+}
+
+// live returns every saved location and subscription list as records.
+// Callers hold s.mu, or own s exclusively.
+func (s *subscriptionStore) live() []interface{} {
+	var live []interface{}
+	for user, loc := range s.locations {
+		loc := loc
+		live = append(live, subscriptionRecord{User: user, Location: &loc})
+	}
+	for user, list := range s.subs {
+		live = append(live, subscriptionRecord{User: user, Subscriptions: list})
+	}
+	return live
+}
+
+// record applies and journals r. Callers hold s.mu.
+func (s *subscriptionStore) record(r subscriptionRecord) {
+	s.apply(r)
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.Append(r); err != nil {
+		log.Println(err)
+	}
+	if err := s.journal.CompactIfDue(s.live); err != nil {
+		log.Println(err)
+	}
+}
+
+// RememberLocation keeps where user is for their next subscription, if they
+// already have one. Nobody else's whereabouts are saved.
+func (s *subscriptionStore) RememberLocation(user string, latitude, longitude float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[user]; !ok {
+		return
+	}
+	s.moveTo(user, savedLocation{Latitude: latitude, Longitude: longitude})
+}
+
+// moveTo records loc as user's location. Callers hold s.mu.
+func (s *subscriptionStore) moveTo(user string, loc savedLocation) {
+	if old, ok := s.locations[user]; ok && old == loc {
+		return
+	}
+	s.record(subscriptionRecord{User: user, Location: &loc})
+}
+
+var (
+	errNeedLocation   = errors.New("subscriptions: no location")
+	errTooManySubs    = fmt.Errorf("subscriptions: more than %d", maxSubscriptions)
+	errInvalidSubTime = errors.New("subscriptions: invalid time")
+)
+
+// Subscribe adds a push at hour:minute near here, or near user's saved
+// location if here is nil, or moves the one already at that time.
+func (s *subscriptionStore) Subscribe(user string, hour, minute int, term string, here *savedLocation) (subscription, error) {
+	if hour > 23 || minute > 59 {
+		return subscription{}, errInvalidSubTime
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if here != nil {
+		s.moveTo(user, *here)
+	}
+	loc, ok := s.locations[user]
+	if !ok {
+		return subscription{}, errNeedLocation
+	}
+	sub := subscription{
+		At:       fmt.Sprintf("%02d:%02d", hour, minute),
+		Zone:     s.zone,
+		Term:     term,
+		Location: loc,
+	}
+
+	list := append([]subscription(nil), s.subs[user]...)
+	replaced := false
+	for i := range list {
+		if list[i].At == sub.At {
+			// keep LastFired so subscribing again does not resend today's push
+			sub.LastFired = list[i].LastFired
+			list[i] = sub
+			replaced = true
+		}
+	}
+	if !replaced {
+		if len(list) >= maxSubscriptions {
+			return subscription{}, errTooManySubs
+		}
+		list = append(list, sub)
+	}
+	s.record(subscriptionRecord{User: user, Subscriptions: list})
+	return sub, nil
+}
+
+// Unsubscribe removes user's push at at, or all of them if at is empty.
+func (s *subscriptionStore) Unsubscribe(user, at string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.subs[user]
+	var kept []subscription
+	for _, sub := range list {
+		if at != "" && sub.At != at {
+			kept = append(kept, sub)
+		}
+	}
+	if len(kept) == len(list) {
+		return false
+	}
+	s.record(subscriptionRecord{User: user, Subscriptions: kept})
+	return true
+}
+
+// Forget drops user's subscriptions and location.
+func (s *subscriptionStore) Forget(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, subscribed := s.subs[user]
+	_, located := s.locations[user]
+	if subscribed || located {
+		s.record(subscriptionRecord{User: user, Forget: true})
+	}
+}
+
+// List returns user's subscriptions.
+func (s *subscriptionStore) List(user string) []subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]subscription(nil), s.subs[user]...)
+}
+
+// Run checks for due pushes every scheduleTick until Close.
+func (s *subscriptionStore) Run() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(scheduleTick)
+		defer ticker.Stop()
+		for {
+			s.fire(time.Now())
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the scheduler and waits for pushes already going out.
+func (s *subscriptionStore) Close() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.pushing.Wait()
+}
+
+// fire sends every push due at now. Each is marked as sent for the day
+// before it goes out: a push lost to a crash is better than a double one.
+func (s *subscriptionStore) fire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for user, list := range s.subs {
+		var due []subscription
+		updated := append([]subscription(nil), list...)
+		for i := range updated {
+			if date, ok := updated[i].due(now); ok {
+				updated[i].LastFired = date
+				due = append(due, updated[i])
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+		s.record(subscriptionRecord{User: user, Subscriptions: updated})
+		for _, sub := range due {
+			s.pushing.Add(1)
+			go func(user string, sub subscription) {
+				defer s.pushing.Done()
+				pushLunch(user, sub)
+			}(user, sub)
+		}
+	}
+}
+
+// pushLunch sends user one recommendation for sub.
+func pushLunch(user string, sub subscription) {
+	prefs := profiles.Get(user)
+	q := parseQuery(sub.Term).PlaceQuery()
+	q.Limit = pageSize
+	q.OpenNow = true
+	prefs.apply(&q)
+	results, err := places.SearchByCoordinate(q, sub.Location.Latitude, sub.Location.Longitude)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var allowed []Place
+	for _, b := range results.Places {
+		if prefs.allows(b) {
+			allowed = append(allowed, b)
+		}
+	}
+	r := newReply(user)
+	shown := recommender.Sample(personalize(user, allowed), 1)
+	if len(shown) == 0 {
+		r.Text(promptLunchPushMissing)
+	} else {
+		r.Text(promptLunchPush)
+		for _, b := range shown {
+			addBusiness(r, user, b)
+		}
+		history.Recommended(user, shown)
+		r.Text(promptFollowUps)
+	}
+	if err := r.Send(); err != nil {
+		log.Println(err)
+	}
+}
+
+// subscriptionCommand subscribes, unsubscribes and lists daily pushes.
+func subscriptionCommand(c *dialogContext, text string) (DialogState, bool) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "訂閱", "subscribe":
+		c.Reply.Text(promptSubscribeHelp)
+		return "", true
+	case "我的訂閱", "subscriptions":
+		list := subscriptions.List(c.User)
+		if len(list) == 0 {
+			c.Reply.Text(promptNoSubscriptions)
+			return "", true
+		}
+		lines := []string{"你的訂閱（週一到週五）："}
+		for _, sub := range list {
+			line := sub.At + " " + sub.Zone
+			if sub.Term != "" {
+				line += " " + sub.Term
+			}
+			lines = append(lines, line)
+		}
+		c.Reply.Text(strings.Join(lines, "\n"))
+		return "", true
+	}
+
+	if m := unsubscribePattern.FindStringSubmatch(text); m != nil {
+		at := ""
+		if m[1] != "" {
+			hour, _ := strconv.Atoi(m[1])
+			minute, _ := strconv.Atoi(m[2])
+			at = fmt.Sprintf("%02d:%02d", hour, minute)
+		}
+		if subscriptions.Unsubscribe(c.User, at) {
+			c.Reply.Text(promptUnsubscribed)
+		} else {
+			c.Reply.Text(promptNoSubscriptions)
+		}
+		return "", true
+	}
+
+	m := subscribePattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	} else if m[3] != "" {
+		minute = 30
+	}
+	// the location of the search the user just made, if they sent one
+	var here *savedLocation
+	if search := c.Session.Search; search != nil && search.Coordinates {
+		here = &savedLocation{Latitude: search.Latitude, Longitude: search.Longitude}
+	}
+	sub, err := subscriptions.Subscribe(c.User, hour, minute, m[4], here)
+	switch err {
+	case nil:
+		what := "餐廳"
+		if sub.Term != "" {
+			what = sub.Term
+		}
+		c.Reply.Text("訂閱成功！週一到週五 " + sub.At + " 推薦你附近的" + what)
+	case errNeedLocation:
+		c.Reply.Text(promptNeedLocation)
+	case errTooManySubs:
+		c.Reply.Text(promptTooManySubs)
+	default:
+		c.Reply.Text(promptSubscribeHelp)
+	}
+	return "", true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionDue(t *testing.T) {
+	// 2026-10-19 is a Monday
+	tests := []struct {
+		zone      string
+		at        string
+		lastFired string
+		now       string
+		want      string
+	}{
+		{"Asia/Taipei", "12:00", "", "2026-10-19T04:00:00Z", "2026-10-19"},
+		{"Asia/Taipei", "12:00", "", "2026-10-19T04:59:00Z", "2026-10-19"},
+		{"Asia/Taipei", "12:00", "", "2026-10-19T05:01:00Z", ""},
+		{"Asia/Taipei", "12:00", "", "2026-10-19T03:59:00Z", ""},
+		{"Asia/Taipei", "12:00", "2026-10-19", "2026-10-19T04:10:00Z", ""},
+		{"Asia/Taipei", "12:00", "2026-10-16", "2026-10-19T04:10:00Z", "2026-10-19"},
+		// noon in New York is the next day in Taipei, but still Monday there
+		{"America/New_York", "12:00", "", "2026-10-19T16:00:00Z", "2026-10-19"},
+		{"America/New_York", "12:00", "", "2026-10-19T04:00:00Z", ""},
+		// Saturday in Taipei, though still Friday in UTC
+		{"Asia/Taipei", "00:30", "", "2026-10-23T16:30:00Z", ""},
+		// Friday in Los Angeles, though already Saturday in UTC
+		{"America/Los_Angeles", "18:00", "", "2026-10-24T01:00:00Z", "2026-10-23"},
+		{"Not/AZone", "12:00", "", "2026-10-19T12:00:00Z", ""},
+	}
+	for _, tt := range tests {
+		now, _ := time.Parse(time.RFC3339, tt.now)
+		sub := subscription{At: tt.at, Zone: tt.zone, LastFired: tt.lastFired}
+		date, ok := sub.due(now)
+		if ok != (tt.want != "") || date != tt.want {
+			t.Errorf("%s %s at %s: due = %q, %v, want %q", tt.zone, tt.at, tt.now, date, ok, tt.want)
+		}
+	}
+}
+
+func TestSubscriptionLocation(t *testing.T) {
+	s := newSubscriptionStore("Asia/Taipei")
+	taipei101 := savedLocation{Latitude: 25.0340, Longitude: 121.5645}
+	station := savedLocation{Latitude: 25.0478, Longitude: 121.5170}
+
+	// searching near somewhere does not save it
+	s.RememberLocation("alice", taipei101.Latitude, taipei101.Longitude)
+	if _, err := s.Subscribe("alice", 12, 0, "", nil); err != errNeedLocation {
+		t.Fatalf("subscribing without a location: %v", err)
+	}
+	if len(s.locations) != 0 {
+		t.Errorf("saved %v for a user without subscriptions", s.locations)
+	}
+
+	sub, err := s.Subscribe("alice", 12, 0, "拉麵", &taipei101)
+	if err != nil || sub.Location != taipei101 || sub.Zone != "Asia/Taipei" {
+		t.Fatalf("Subscribe = %+v, %v", sub, err)
+	}
+	// a subscriber's later searches move their next subscription
+	s.RememberLocation("alice", station.Latitude, station.Longitude)
+	if sub, _ := s.Subscribe("alice", 11, 30, "", nil); sub.Location != station {
+		t.Errorf("second subscription at %v, want %v", sub.Location, station)
+	}
+	if list := s.List("alice"); len(list) != 2 || list[0].Location != taipei101 {
+		t.Errorf("subscriptions = %+v", list)
+	}
+
+	// the location goes with the last subscription
+	s.Unsubscribe("alice", "")
+	if _, ok := s.locations["alice"]; ok {
+		t.Error("location kept after unsubscribing")
+	}
+}
+
+func TestSubscriptionJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "subscriptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subscriptions.log")
+
+	// a journal from before locations were only kept for subscribers
+	j, err := openJournal(path, func([]byte) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Append(subscriptionRecord{User: "bob", Location: &savedLocation{Latitude: 25, Longitude: 121}})
+	j.Close()
+
+	s, err := openSubscriptionStore(path, "Asia/Taipei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.locations) != 0 {
+		t.Errorf("kept %v from the old journal", s.locations)
+	}
+	here := savedLocation{Latitude: 25.0340, Longitude: 121.5645}
+	s.Subscribe("alice", 12, 0, "拉麵", &here)
+	s.Subscribe("carol", 12, 0, "", &here)
+	s.Unsubscribe("carol", "12:00")
+	s.journal.Close()
+
+	s, err = openSubscriptionStore(path, "Asia/Taipei")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.journal.Close()
+	list := s.List("alice")
+	if len(list) != 1 || list[0].Term != "拉麵" || s.locations["alice"] != here {
+		t.Errorf("after a restart: %+v at %v", list, s.locations["alice"])
+	}
+	if _, ok := s.locations["carol"]; ok {
+		t.Error("carol's location came back after unsubscribing")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+var errQueueFull = errors.New("event queue is full")
+var errQueueClosed = errors.New("event queue is closed")
+
+// queue metrics, published on /debug/vars
+var (
+	queueEnqueued  = expvar.NewInt("queue_enqueued")
+	queueRejected  = expvar.NewInt("queue_rejected")
+	queueProcessed = expvar.NewInt("queue_processed")
+	queuePanics    = expvar.NewInt("queue_panics")
+)
+
+// publishDepth publishes queue_depth for the first queue made, which in the
+// bot is the only one.
+var publishDepth sync.Once
+
+// queuedEvent is a received event, or work the bot does for a user outside
+// of one, such as answering a card button.
+type queuedEvent struct {
+	id     string
+	handle func()
+}
+
+// eventQueue runs received events on a fixed set of workers. Every user is
+// pinned to one worker, so a user's events are handled in the order they
+// arrived while different users proceed in parallel.
+type eventQueue struct {
+	mu      sync.RWMutex
+	closed  bool
+	lanes   []chan queuedEvent
+	wg      sync.WaitGroup
+	handler func(*linebot.ReceivedResult)
+}
+
+// newEventQueue starts workers goroutines sharing size queued events.
+func newEventQueue(workers, size int, handler func(*linebot.ReceivedResult)) *eventQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	perLane := size / workers
+	if perLane < 1 {
+		perLane = 1
+	}
+	q := &eventQueue{
+		lanes:   make([]chan queuedEvent, workers),
+		handler: handler,
+	}
+	for i := range q.lanes {
+		q.lanes[i] = make(chan queuedEvent, perLane)
+		q.wg.Add(1)
+		go q.work(q.lanes[i])
+	}
+	publishDepth.Do(func() {
+		expvar.Publish("queue_depth", expvar.Func(func() interface{} {
+			return q.Depth()
+		}))
+	})
+	return q
+}
+
+// Enqueue schedules result for processing without blocking. It returns
+// errQueueFull when the user's worker is backed up.
+func (q *eventQueue) Enqueue(result *linebot.ReceivedResult) error {
+	return q.push(eventUser(result), queuedEvent{
+		id:     result.ID,
+		handle: func() { q.handler(result) },
+	})
+}
+
+// EnqueueFunc schedules fn on user's worker, after the events of theirs
+// already queued. id names it in logs.
+func (q *eventQueue) EnqueueFunc(user, id string, fn func()) error {
+	return q.push(user, queuedEvent{id: id, handle: fn})
+}
+
+func (q *eventQueue) push(user string, e queuedEvent) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errQueueClosed
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(user))
+	lane := q.lanes[h.Sum32()%uint32(len(q.lanes))]
+	select {
+	case lane <- e:
+		queueEnqueued.Add(1)
+		return nil
+	default:
+		queueRejected.Add(1)
+		return errQueueFull
+	}
+}
+
+// Depth returns the number of events waiting across all workers.
+func (q *eventQueue) Depth() int {
+	n := 0
+	for _, lane := range q.lanes {
+		n += len(lane)
+	}
+	return n
+}
+
+// Close stops accepting events and waits up to timeout for the queued ones
+// to finish.
+func (q *eventQueue) Close(timeout time.Duration) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		for _, lane := range q.lanes {
+			close(lane)
+		}
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("timed out draining event queue")
+	}
+}
+
+func (q *eventQueue) work(lane chan queuedEvent) {
+	defer q.wg.Done()
+	for e := range lane {
+		q.run(e)
+	}
+}
+
+func (q *eventQueue) run(e queuedEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			queuePanics.Add(1)
+			log.Printf("panic handling event %s: %v", e.id, r)
+		}
+	}()
+	e.handle()
+	queueProcessed.Add(1)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/linebot"
+)
+
+func testEvent(user string, n int) *linebot.ReceivedResult {
+	r := &linebot.ReceivedResult{ID: fmt.Sprintf("%s-%d", user, n)}
+	r.RawContent.From = user
+	return r
+}
+
+func TestEventQueueKeepsEachUsersOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[string][]string)
+	q := newEventQueue(4, 400, func(r *linebot.ReceivedResult) {
+		// later events of a user must still wait for a slow first one
+		if r.ID == "alice-0" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		mu.Lock()
+		handled[r.RawContent.From] = append(handled[r.RawContent.From], r.ID)
+		mu.Unlock()
+	})
+
+	users := []string{"alice", "bob", "carol", "dave", "erin"}
+	want := make(map[string][]string)
+	for n := 0; n < 10; n++ {
+		for _, user := range users {
+			if err := q.Enqueue(testEvent(user, n)); err != nil {
+				t.Fatal(err)
+			}
+			want[user] = append(want[user], fmt.Sprintf("%s-%d", user, n))
+		}
+	}
+	done := make(chan struct{})
+	if err := q.EnqueueFunc("alice", "alice-last", func() {
+		mu.Lock()
+		handled["alice"] = append(handled["alice"], "alice-last")
+		mu.Unlock()
+		close(done)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want["alice"] = append(want["alice"], "alice-last")
+
+	if err := q.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+}
+
+func TestEventQueueCloseDrains(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var handled int
+	q := newEventQueue(1, 10, func(r *linebot.ReceivedResult) {
+		<-release
+		mu.Lock()
+		handled++
+		mu.Unlock()
+	})
+	for n := 0; n < 5; n++ {
+		if err := q.Enqueue(testEvent("alice", n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// nothing finishes while the handler is held, so Close times out
+	if err := q.Close(10 * time.Millisecond); err == nil {
+		t.Error("Close returned before the queue drained")
+	}
+	if err := q.Enqueue(testEvent("alice", 5)); err != errQueueClosed {
+		t.Errorf("Enqueue after Close: err = %v", err)
+	}
+
+	close(release)
+	if err := q.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if handled != 5 {
+		t.Errorf("handled %d of the 5 queued events", handled)
+	}
+}
+
+func TestEventQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	q := newEventQueue(1, 2, func(r *linebot.ReceivedResult) {
+		started <- struct{}{}
+		<-release
+	})
+	defer q.Close(time.Second)
+	defer close(release)
+
+	q.Enqueue(testEvent("alice", 0))
+	<-started
+	for n := 1; n <= 2; n++ {
+		if err := q.Enqueue(testEvent("alice", n)); err != nil {
+			t.Fatalf("event %d: %v", n, err)
+		}
+	}
+	if err := q.Enqueue(testEvent("alice", 3)); err != errQueueFull {
+		t.Errorf("third waiting event: err = %v", err)
+	}
+	if q.Depth() != 2 {
+		t.Errorf("depth = %d", q.Depth())
+	}
+}
+
+func TestEventQueueRecovers(t *testing.T) {
+	var handled []string
+	q := newEventQueue(1, 4, func(r *linebot.ReceivedResult) {
+		if r.ID == "alice-0" {
+			panic("boom")
+		}
+		handled = append(handled, r.ID)
+	})
+	q.Enqueue(testEvent("alice", 0))
+	q.Enqueue(testEvent("alice", 1))
+	if err := q.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(handled, []string{"alice-1"}) {
+		t.Errorf("handled %v after a panic", handled)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/JustinBeckwith/go-yelp/yelp"
+	"github.com/JustinBeckwith/oauth"
+	"github.com/guregu/null"
+)
+
+// term used when a query names neither a food nor a category
+const defaultFood = "food,restaurants"
+
+// yelpProvider is a PlaceProvider over the Yelp v2 API.
+type yelpProvider struct {
+	client *yelp.Client
+}
+
+func newYelpProvider(auth *yelp.AuthOptions) *yelpProvider {
+	return &yelpProvider{client: yelp.New(auth, nil)}
+}
+
+func (y *yelpProvider) SearchByCoordinate(q PlaceQuery, latitude, longitude float64) (PlaceResults, error) {
+	return y.search(yelp.SearchOptions{
+		GeneralOptions: yelpGeneralOptions(q),
+		CoordinateOptions: &yelp.CoordinateOptions{
+			Latitude:  null.FloatFrom(latitude),
+			Longitude: null.FloatFrom(longitude),
+		},
+	})
+}
+
+func (y *yelpProvider) SearchByText(q PlaceQuery, location string, hint *LatLng) (PlaceResults, error) {
+	opts := yelp.SearchOptions{
+		GeneralOptions:  yelpGeneralOptions(q),
+		LocationOptions: &yelp.LocationOptions{Location: location},
+	}
+	if hint != nil {
+		opts.LocationOptions.CoordinateOptions = &yelp.CoordinateOptions{
+			Latitude:  null.FloatFrom(hint.Latitude),
+			Longitude: null.FloatFrom(hint.Longitude),
+		}
+	}
+	results, err := y.search(opts)
+	if e, ok := err.(oauth.HTTPExecuteError); ok && strings.Contains(string(e.ResponseBodyBytes), "UNAVAILABLE_FOR_LOCATION") {
+		return PlaceResults{}, errUnknownLocation
+	}
+	return results, err
+}
+
+func (y *yelpProvider) search(opts yelp.SearchOptions) (PlaceResults, error) {
+	result, err := y.client.DoSearch(opts)
+	if err != nil {
+		return PlaceResults{}, err
+	}
+	places := make([]Place, len(result.Businesses))
+	for i, b := range result.Businesses {
+		places[i] = yelpPlace(b)
+	}
+	return PlaceResults{Total: result.Total, Places: places}, nil
+}
+
+// Details maps go-yelp's unexported errBusinessNotFound, which can only be
+// told apart by its text, to errPlaceNotFound.
+func (y *yelpProvider) Details(id string) (Place, error) {
+	b, err := y.client.GetBusiness(id)
+	if err != nil {
+		if err.Error() == "business not found" {
+			return Place{}, errPlaceNotFound
+		}
+		return Place{}, err
+	}
+	return yelpPlace(b), nil
+}
+
+func yelpGeneralOptions(q PlaceQuery) *yelp.GeneralOptions {
+	g := &yelp.GeneralOptions{
+		Term:           q.Term,
+		Sort:           q.Sort,
+		RadiusFilter:   q.Radius,
+		DealsFilter:    q.Deals,
+		CategoryFilter: strings.Join(q.Categories, ","),
+	}
+	if g.Term == "" && g.CategoryFilter == "" {
+		g.Term = defaultFood
+	}
+	if q.Limit > 0 {
+		g.Limit = null.IntFrom(int64(q.Limit))
+	}
+	if q.Offset > 0 {
+		g.Offset = null.IntFrom(int64(q.Offset))
+	}
+	return g
+}
+
+// yelpPlace converts b, asking for the large version of its photo.
+func yelpPlace(b yelp.Business) Place {
+	p := Place{
+		ID:            b.ID,
+		Name:          b.Name,
+		URL:           b.MobileURL,
+		PhotoURL:      strings.Replace(b.ImageURL, "ms.jpg", "l.jpg", 1),
+		Phone:         b.Phone,
+		Rating:        float64(b.Rating),
+		ReviewCount:   b.ReviewCount,
+		Distance:      float64(b.Distance),
+		Address:       b.Location.DisplayAddress,
+		Latitude:      float64(b.Location.Coordinate.Latitude),
+		Longitude:     float64(b.Location.Coordinate.Longitude),
+		IsClosed:      b.IsClosed,
+		CrossStreets:  b.Location.CrossStreets,
+		Neighborhoods: b.Location.Neighborhoods,
+		Snippet:       b.SnippetText,
+	}
+	for _, c := range b.Categories {
+		var pc PlaceCategory
+		if len(c) > 0 {
+			pc.Name = c[0]
+		}
+		if len(c) > 1 {
+			pc.Alias = c[1]
+		}
+		p.Categories = append(p.Categories, pc)
+	}
+	for _, r := range b.Reviews {
+		p.Reviews = append(p.Reviews, PlaceReview{
+			Rating:  float64(r.Rating),
+			Excerpt: r.Excerpt,
+			Author:  r.User.Name,
+		})
+	}
+	for _, d := range b.Deals {
+		deal := PlaceDeal{Title: d.Title}
+		for _, opt := range d.Options {
+			deal.Options = append(deal.Options, PlaceOffer{
+				Title:         opt.Title,
+				Price:         opt.FormattedPrice,
+				OriginalPrice: opt.FormattedOriginalPrice,
+			})
+		}
+		p.Deals = append(p.Deals, deal)
+	}
+	for _, g := range b.GiftCertificates {
+		var gift PlaceGiftOption
+		for _, opt := range g.Options {
+			gift.Prices = append(gift.Prices, opt.FormattedPrice)
+		}
+		p.GiftCertificates = append(p.GiftCertificates, gift)
+	}
+	return p
+}